@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// Secrets are encrypted in-process to the same format agenix writes: a binary
+// age file with a stanza for every key secrets.nix lists for its path, so
+// agenix and the NixOS module keep reading them unchanged.
+
+// secretFilePath is where a secrets.nix entry lives on disk.
+func secretFilePath(ageEntry string) string {
+	path := filepath.FromSlash(ageEntry)
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(settings.RepoRoot, path)
+}
+
+// secretRecipientKeys returns the public keys secrets.nix lists for an entry,
+// with references to let-bound keys resolved.
+func secretRecipientKeys(secretsPath, ageEntry string) ([]string, error) {
+	secrets, err := loadSecretsNix(secretsPath)
+	if err != nil {
+		return nil, err
+	}
+	return secrets.recipients(ageEntry)
+}
+
+// parseRecipient accepts the keys agenix does: ssh-ed25519 and ssh-rsa
+// public keys, and native age1 keys.
+func parseRecipient(key string) (age.Recipient, error) {
+	if strings.HasPrefix(key, "age1") {
+		return age.ParseX25519Recipient(key)
+	}
+	return agessh.ParseRecipient(key)
+}
+
+// secretRecipients parses the keys secrets.nix lists for an entry.
+func secretRecipients(ageEntry string) ([]age.Recipient, error) {
+	keys, err := secretRecipientKeys(secretsNixPath(), ageEntry)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%q has an empty publicKeys list in secrets.nix", ageEntry)
+	}
+	recipients := make([]age.Recipient, 0, len(keys))
+	for _, key := range keys {
+		r, err := parseRecipient(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key for %q: %w", ageEntry, err)
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, nil
+}
+
+// identityPaths are the private keys tried for decryption: the configured
+// identity, or the ssh keys agenix falls back to.
+func identityPaths() ([]string, error) {
+	if settings.Identity != "" {
+		return []string{settings.Identity}, nil
+	}
+	var paths []string
+	for _, name := range []string{"id_ed25519", "id_rsa"} {
+		path := filepath.Join(os.Getenv("HOME"), ".ssh", name)
+		if fileExists(path) {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no identity found; pass --identity or set identity in %s", settingsFileName)
+	}
+	return paths, nil
+}
+
+// loadedIdentities caches loadIdentities, so a passphrase is asked for once
+// per run rather than once per secret.
+var loadedIdentities []age.Identity
+
+// loadIdentities reads the private keys to decrypt with.
+func loadIdentities() ([]age.Identity, error) {
+	if loadedIdentities != nil {
+		return loadedIdentities, nil
+	}
+	paths, err := identityPaths()
+	if err != nil {
+		return nil, err
+	}
+	var identities []age.Identity
+	for _, path := range paths {
+		ids, err := parseIdentityFile(path)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, ids...)
+	}
+	loadedIdentities = identities
+	return identities, nil
+}
+
+// parseIdentityFile reads an ssh private key or an age identity file. For
+// passphrase-protected ssh keys the passphrase is only asked for once a
+// secret is actually encrypted to the key.
+func parseIdentityFile(path string) ([]age.Identity, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity: %w", err)
+	}
+	if bytes.Contains(content, []byte("AGE-SECRET-KEY-")) {
+		ids, err := age.ParseIdentities(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return ids, nil
+	}
+
+	id, err := agessh.ParseIdentity(content)
+	if err == nil {
+		return []age.Identity{id}, nil
+	}
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	pub := missing.PublicKey
+	if pub == nil {
+		// older key formats don't carry the public key unencrypted
+		data, err := os.ReadFile(path + ".pub")
+		if err != nil {
+			return nil, fmt.Errorf("%s is passphrase protected and its .pub file can't be read: %w", path, err)
+		}
+		if pub, _, _, _, err = ssh.ParseAuthorizedKey(data); err != nil {
+			return nil, fmt.Errorf("%s.pub: %w", path, err)
+		}
+	}
+	encrypted, err := agessh.NewEncryptedSSHIdentity(pub, content, func() ([]byte, error) {
+		return promptPassphrase(path)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return []age.Identity{encrypted}, nil
+}
+
+func promptPassphrase(path string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("%s is passphrase protected and stdin is not a terminal", path)
+	}
+	// stderr keeps the prompt out of decrypted output piped elsewhere
+	fmt.Fprint(os.Stderr, promptStyle.Render("Passphrase for "+path+": "))
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return passphrase, err
+}
+
+// encryptSecretData encrypts data to the recipients secrets.nix lists for an
+// entry and writes it to the entry's .age file.
+func encryptSecretData(data []byte, ageEntry string) error {
+	recipients, err := secretRecipients(ageEntry)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipients...)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", ageEntry, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", ageEntry, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", ageEntry, err)
+	}
+
+	if err := atomicWriteFile(secretFilePath(ageEntry), buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	fmt.Println(successStyle.Render("✓ Successfully encrypted secret to " + ageEntry))
+	return nil
+}
+
+// decryptSecretData decrypts an entry's .age file with the local identity.
+func decryptSecretData(ageEntry string) ([]byte, error) {
+	identities, err := loadIdentities()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(secretFilePath(ageEntry))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := age.Decrypt(f, identities...)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return nil, fmt.Errorf("%s is not encrypted to your identity; ask someone who can read it to add your key and rekey", ageEntry)
+		}
+		return nil, fmt.Errorf("failed to decrypt %s: %w", ageEntry, err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", ageEntry, err)
+	}
+	return data, nil
+}
+
+// createAndEncryptSecret encrypts a plain-text environment file to an app's
+// secret, once it has every key the app requires. Keys in provided are added
+// to the secret later, e.g. by companion services.
+func createAndEncryptSecret(sourceEnvFile, appName, appsDir string, provided ...string) error {
+	content, err := os.ReadFile(sourceEnvFile)
+	if err != nil {
+		return fmt.Errorf("could not read source env file %s: %w", sourceEnvFile, err)
+	}
+	schema, err := appEnvSchema(filepath.Dir(appsDir), appName)
+	if err != nil {
+		return err
+	}
+	if err := checkRequiredEnv(schema, content, provided...); err != nil {
+		return fmt.Errorf("%s: %w", sourceEnvFile, err)
+	}
+
+	encryptedFilePath := ageEntryPath(filepath.Dir(appsDir), appName)
+	fmt.Println(promptStyle.Render(fmt.Sprintf("🔐 Encrypting %s to %s", sourceEnvFile, encryptedFilePath)))
+	return encryptSecretData(content, encryptedFilePath)
+}
+
+// openSecretEditor decrypts an app's secret, if it exists, into a private
+// temporary file, opens $EDITOR on it and encrypts the result back.
+func openSecretEditor(appName, appsDir string, provided ...string) error {
+	schema, err := appEnvSchema(filepath.Dir(appsDir), appName)
+	if err != nil {
+		return err
+	}
+	encryptedFilePath := ageEntryPath(filepath.Dir(appsDir), appName)
+	return editSecret(encryptedFilePath, schema, provided...)
+}
+
+// editSecret is openSecretEditor for any secrets.nix entry. The edited
+// secret must still have the keys schema requires.
+func editSecret(ageEntry string, schema EnvSchema, provided ...string) error {
+	var plain []byte
+	existed := fileExists(secretFilePath(ageEntry))
+	if existed {
+		var err error
+		if plain, err = decryptSecretData(ageEntry); err != nil {
+			return err
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "rollout-secret-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, strings.TrimSuffix(filepath.Base(ageEntry), ".age"))
+	if err := os.WriteFile(tmp, plain, 0o600); err != nil {
+		return err
+	}
+
+	fmt.Println(promptStyle.Render(fmt.Sprintf("🔐 Opening editor for %s", ageEntry)))
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	// through the shell, since $EDITOR may carry arguments
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", tmp)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor failed: %w", err)
+	}
+
+	edited, err := os.ReadFile(tmp)
+	if err != nil {
+		return err
+	}
+	if existed && bytes.Equal(edited, plain) {
+		fmt.Println(mutedStyle.Render("ℹ️ " + ageEntry + " unchanged"))
+		return nil
+	}
+	if err := checkRequiredEnv(schema, edited, provided...); err != nil {
+		return fmt.Errorf("%s not saved: %w", ageEntry, err)
+	}
+	return encryptSecretData(edited, ageEntry)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// writeSSHKey writes a fresh ssh-ed25519 private key to dir/name and returns
+// its path and authorized_keys line.
+func writeSSHKey(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+}
+
+// useIdentity points decryption at a key, as --identity does.
+func useIdentity(path string) {
+	settings.Identity = path
+	loadedIdentities = nil
+}
+
+func TestSecretRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	saved := settings
+	t.Cleanup(func() {
+		settings = saved
+		loadedIdentities = nil
+	})
+	settings.RepoRoot = dir
+
+	reader, readerPub := writeSSHKey(t, dir, "reader")
+	outsider, _ := writeSSHKey(t, dir, "outsider")
+
+	// recipients go through a let-bound group of let-bound keys
+	secretsNix := "let\n" +
+		"  reader = " + nixQuote(readerPub) + ";\n" +
+		"  admins = [ reader ];\n" +
+		"in\n" +
+		"{\n" +
+		"  \"apps/web.age\".publicKeys = admins;\n" +
+		"}\n"
+	if err := os.WriteFile(filepath.Join(dir, "secrets.nix"), []byte(secretsNix), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "apps"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := secretRecipientKeys(secretsNixPath(), "apps/web.age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != readerPub {
+		t.Fatalf("recipients = %q, want [%q]", keys, readerPub)
+	}
+
+	plain := []byte("SECRET_KEY=hunter2\n")
+	if err := encryptSecretData(plain, "apps/web.age"); err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := os.ReadFile(filepath.Join(dir, "apps", "web.age"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(encrypted), "hunter2") {
+		t.Fatal("secret was written in plain text")
+	}
+
+	useIdentity(reader)
+	got, err := decryptSecretData("apps/web.age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(plain) {
+		t.Fatalf("decrypted %q, want %q", got, plain)
+	}
+
+	useIdentity(outsider)
+	_, err = decryptSecretData("apps/web.age")
+	if err == nil {
+		t.Fatal("decrypted with a key the secret isn't encrypted to")
+	}
+	if want := "apps/web.age is not encrypted to your identity"; !strings.Contains(err.Error(), want) {
+		t.Fatalf("error = %q, want it to contain %q", err, want)
+	}
+}
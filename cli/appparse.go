@@ -0,0 +1,681 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AppParseError lists the parts of an app file that do not match what
+// NixAppConfig.Generate produces, typically because it was edited by hand.
+// The config returned alongside it holds every field that could be read.
+type AppParseError struct {
+	File   string
+	Issues []string
+}
+
+func (e *AppParseError) Error() string {
+	name := e.File
+	if name == "" {
+		name = "app config"
+	}
+	return fmt.Sprintf("%s has unrecognized content:\n  - %s", name, strings.Join(e.Issues, "\n  - "))
+}
+
+// parseAppFile reads a generated app file back into a NixAppConfig. The file
+// name must match the container name.
+func parseAppFile(path string) (*NixAppConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := parseNixApp(string(content))
+	if perr, ok := err.(*AppParseError); ok {
+		perr.File = path
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	expected := strings.TrimSuffix(filepath.Base(path), ".nix")
+	if config.Name != expected {
+		issue := fmt.Sprintf("container is named %q but the file is %s.nix", config.Name, expected)
+		if perr, ok := err.(*AppParseError); ok {
+			perr.Issues = append(perr.Issues, issue)
+		} else {
+			err = &AppParseError{File: path, Issues: []string{issue}}
+		}
+	}
+
+	return config, err
+}
+
+// parseNixApp turns the output of NixAppConfig.Generate back into a config.
+// Syntax errors return a nil config. Content that parses but isn't something
+// Generate would emit is reported as an *AppParseError together with the
+// partially filled config, so callers can decide whether to trust it.
+func parseNixApp(src string) (*NixAppConfig, error) {
+	root, err := parseNix(src)
+	if err != nil {
+		return nil, err
+	}
+	if root.Kind != nixAttrs {
+		return nil, &nixSyntaxError{root.Line, "expected an attribute set"}
+	}
+
+	r := &appReader{config: &NixAppConfig{}, auxiliary: map[string]string{}}
+	r.read(root)
+
+	if len(r.issues) > 0 {
+		return r.config, &AppParseError{Issues: r.issues}
+	}
+	return r.config, nil
+}
+
+// nixLeaf is an assignment with its attribute path fully expanded, so that
+// `a = { b = 1; };` and `a.b = 1;` are treated the same.
+type nixLeaf struct {
+	Path  []string
+	Value *nixNode
+	Line  int
+}
+
+func flattenNixAttrs(prefix []string, node *nixNode, out *[]nixLeaf) {
+	for _, b := range node.Attrs {
+		path := append(append([]string{}, prefix...), b.Path...)
+		// labels and environment are the only nested sets kept as leaves
+		if b.Value.Kind == nixAttrs && !isContainerSetPath(path) {
+			flattenNixAttrs(path, b.Value, out)
+			continue
+		}
+		*out = append(*out, nixLeaf{Path: path, Value: b.Value, Line: b.Line})
+	}
+}
+
+func isContainerSetPath(path []string) bool {
+	return len(path) == 5 && hasPathPrefix(path, "virtualisation", "oci-containers", "containers") &&
+		(path[4] == "labels" || path[4] == "environment")
+}
+
+func hasPathPrefix(path []string, prefix ...string) bool {
+	if len(path) < len(prefix) {
+		return false
+	}
+	for i, p := range prefix {
+		if path[i] != p {
+			return false
+		}
+	}
+	return true
+}
+
+type appReader struct {
+	config *NixAppConfig
+	issues []string
+
+	pullImage string
+	envFile   string
+	ageFile   string
+	authFile  string
+	authOwner string
+
+	extraOptions []string
+	networks     []string
+	dependsOn    []string
+	auxiliary    map[string]string // companion service and network unit leaves, by path
+}
+
+func (r *appReader) issuef(line int, format string, args ...any) {
+	r.issues = append(r.issues, fmt.Sprintf("line %d: ", line)+fmt.Sprintf(format, args...))
+}
+
+func (r *appReader) read(root *nixNode) {
+	var leaves []nixLeaf
+	flattenNixAttrs(nil, root, &leaves)
+
+	// the container name anchors every other attribute; any other container
+	// must be one of its companion services
+	var containers []string
+	for _, leaf := range leaves {
+		if len(leaf.Path) > 4 && hasPathPrefix(leaf.Path, "virtualisation", "oci-containers", "containers") &&
+			!containsString(containers, leaf.Path[3]) {
+			containers = append(containers, leaf.Path[3])
+		}
+	}
+	if len(containers) == 0 {
+		r.issues = append(r.issues, "no virtualisation.oci-containers.containers entry found")
+		return
+	}
+	for _, candidate := range containers {
+		companions := true
+		for _, other := range containers {
+			if other != candidate && !isCompanionPath(candidate, []string{"virtualisation", "oci-containers", "containers", other}) {
+				companions = false
+			}
+		}
+		if companions {
+			r.config.Name = candidate
+			break
+		}
+	}
+	if r.config.Name == "" {
+		r.issues = append(r.issues, fmt.Sprintf("more than one container defined (%s)", strings.Join(containers, ", ")))
+		return
+	}
+
+	name := r.config.Name
+	for _, s := range companionServiceNames {
+		if containsString(containers, companionName(name, s)) {
+			r.config.Services = append(r.config.Services, s)
+		}
+	}
+	var labels *nixLeaf
+	for i, leaf := range leaves {
+		path := leaf.Path
+		switch {
+		case hasPathPrefix(path, "virtualisation", "oci-containers", "containers", name) && len(path) == 5:
+			if path[4] == "labels" {
+				labels = &leaves[i]
+				continue
+			}
+			r.readContainerAttr(path[4], leaf)
+		case len(path) == 5 && hasPathPrefix(path, "systemd", "services", "docker-"+name, "serviceConfig", "ExecStartPre"):
+			r.readPull(leaf)
+		case len(path) == 5 && hasPathPrefix(path, "systemd", "services", "docker-"+name, "serviceConfig", "Restart"):
+			r.readRestart(leaf)
+		case isCompanionPath(name, path) || isNetworkUnitPath(path):
+			r.auxiliary[nixLeafKey(path)] = nixValueText(leaf.Value)
+		case len(path) == 4 && hasPathPrefix(path, "age", "secrets", name, "file"):
+			if s, ok := r.pathValue(leaf); ok {
+				r.ageFile = s
+			}
+		case len(path) == 4 && hasPathPrefix(path, "age", "secrets", basicAuthSecretName(name), "file"):
+			if s, ok := r.pathValue(leaf); ok {
+				r.authFile = s
+			}
+		case len(path) == 4 && hasPathPrefix(path, "age", "secrets", basicAuthSecretName(name), "owner"):
+			if s, ok := r.stringValue(leaf); ok {
+				r.authOwner = s
+			}
+		default:
+			r.issuef(leaf.Line, "unrecognized attribute %s", formatNixPath(path))
+		}
+	}
+
+	// an app with neither labels nor a port binding is internal; its
+	// networks are all companion or joined ones
+	r.config.Internal = labels == nil && r.config.HostPort == 0
+	networks := r.networks
+	if !r.config.Internal && len(networks) > 0 {
+		r.config.Network, networks = networks[0], networks[1:]
+	}
+	if len(r.config.Services) > 0 && len(networks) > 0 && networks[0] == privateNetwork(name) {
+		networks = networks[1:]
+	}
+	if len(networks) > 0 {
+		r.config.JoinNetworks = networks
+	}
+
+	switch {
+	case labels != nil:
+		r.readLabels(*labels)
+	case !r.config.Internal:
+		r.issues = append(r.issues, "no traefik labels found")
+	}
+
+	r.checkConsistency()
+}
+
+func (r *appReader) readContainerAttr(attr string, leaf nixLeaf) {
+	switch attr {
+	case "image":
+		if s, ok := r.stringValue(leaf); ok {
+			r.config.Image = s
+		}
+	case "ports":
+		ports, ok := r.stringList(leaf)
+		if !ok {
+			return
+		}
+		if len(ports) != 1 {
+			r.issuef(leaf.Line, "expected exactly one port binding, found %d", len(ports))
+			return
+		}
+		m := portBindingPattern.FindStringSubmatch(ports[0])
+		if m == nil {
+			r.issuef(leaf.Line, "port binding %q is not of the form 127.0.0.1:<host>:<container>", ports[0])
+			return
+		}
+		r.config.HostPort, _ = strconv.Atoi(m[1])
+		r.config.ContainerPort, _ = strconv.Atoi(m[2])
+	case "networks":
+		networks, ok := r.stringList(leaf)
+		if !ok || len(networks) == 0 {
+			return
+		}
+		// split into traefik's, the private and joined ones once it's
+		// known whether the app is internal
+		r.networks = networks
+	case "dependsOn":
+		if deps, ok := r.stringList(leaf); ok {
+			r.dependsOn = deps
+		}
+	case "volumes":
+		if mounts, ok := r.stringList(leaf); ok {
+			r.config.Mounts = mounts
+		}
+	case "environment":
+		if leaf.Value.Kind != nixAttrs {
+			r.issuef(leaf.Line, "environment must be an attribute set, found %s", leaf.Value.Kind)
+			return
+		}
+		env := map[string]string{}
+		for _, b := range leaf.Value.Attrs {
+			if len(b.Path) != 1 || b.Value.Kind != nixString {
+				r.issuef(b.Line, "environment.%s must be a string", formatNixPath(b.Path))
+				continue
+			}
+			env[b.Path[0]] = b.Value.Str
+		}
+		r.config.Environment = env
+	case "extraOptions":
+		opts, ok := r.stringList(leaf)
+		if !ok {
+			return
+		}
+		for _, opt := range opts {
+			flag, value, _ := strings.Cut(opt, "=")
+			if !r.config.readResourceOption(flag, value) && !r.config.readHealthOption(flag, value) {
+				r.issuef(leaf.Line, "unrecognized docker option %q", opt)
+			}
+		}
+		r.extraOptions = opts
+	case "environmentFiles":
+		if leaf.Value.Kind != nixList || len(leaf.Value.Items) != 1 || leaf.Value.Items[0].Kind != nixSelect {
+			r.issuef(leaf.Line, "environmentFiles must reference a single agenix secret")
+			return
+		}
+		r.envFile = leaf.Value.Items[0].Str
+	default:
+		r.issuef(leaf.Line, "unrecognized container attribute %q", attr)
+	}
+}
+
+var (
+	portBindingPattern = regexp.MustCompile(`^127\.0\.0\.1:(\d+):(\d+)$`)
+	pullCommandPattern = regexp.MustCompile(`^\$\{pkgs\.docker\}/bin/docker pull (.+)$`)
+	hostMatcherPattern = regexp.MustCompile("^Host\\(`([^`]+)`\\)$")
+
+	pathPrefixRulePattern = regexp.MustCompile("^(.+) && PathPrefix\\(`([^`]+)`\\)$")
+)
+
+func (r *appReader) readPull(leaf nixLeaf) {
+	cmds, ok := r.stringList(leaf)
+	if !ok {
+		return
+	}
+	if len(cmds) != 1 {
+		r.issuef(leaf.Line, "expected a single ExecStartPre command, found %d", len(cmds))
+		return
+	}
+	m := pullCommandPattern.FindStringSubmatch(cmds[0])
+	if m == nil {
+		r.issuef(leaf.Line, "ExecStartPre %q is not a docker pull", cmds[0])
+		return
+	}
+	r.pullImage = m[1]
+}
+
+// readRestart reads the restart policy, which overrides the oci-containers
+// default with pkgs.lib.mkForce.
+func (r *appReader) readRestart(leaf nixLeaf) {
+	v := leaf.Value
+	if v.Kind != nixApply || v.Str != "pkgs.lib.mkForce" || len(v.Items) != 1 || v.Items[0].Kind != nixString {
+		r.issuef(leaf.Line, "restart policy must be pkgs.lib.mkForce \"<policy>\"")
+		return
+	}
+	r.config.Restart = v.Items[0].Str
+}
+
+func (r *appReader) readLabels(leaf nixLeaf) {
+	name := r.config.Name
+	found := map[string]string{}
+
+	for _, b := range leaf.Value.Attrs {
+		if len(b.Path) != 1 {
+			r.issuef(b.Line, "unrecognized label %s", formatNixPath(b.Path))
+			continue
+		}
+		if b.Value.Kind != nixString {
+			r.issuef(b.Line, "label %q must be a string, found %s", b.Path[0], b.Value.Kind)
+			continue
+		}
+		found[b.Path[0]] = b.Value.Str
+
+		switch b.Path[0] {
+		case "traefik.enable",
+			"traefik.docker.network",
+			"traefik.http.services." + name + ".loadbalancer.server.port",
+			"traefik.http.routers." + name + ".rule",
+			"traefik.http.routers." + name + ".entrypoints",
+			"traefik.http.routers." + name + ".tls.certresolver",
+			"traefik.http.routers." + name + ".priority",
+			"traefik.http.routers." + name + ".middlewares":
+		default:
+			// middleware and healthcheck labels are checked as a whole below
+			if !strings.HasPrefix(b.Path[0], "traefik.http.middlewares."+name+"-") &&
+				!strings.HasPrefix(b.Path[0], "traefik.http.services."+name+".loadbalancer.healthcheck.") {
+				r.issuef(b.Line, "unrecognized label %q", b.Path[0])
+			}
+		}
+	}
+
+	expect := func(key, want string) {
+		got, ok := found[key]
+		if !ok {
+			r.issuef(leaf.Line, "missing label %q", key)
+		} else if got != want {
+			r.issuef(leaf.Line, "label %q is %q, expected %q", key, got, want)
+		}
+	}
+	expect("traefik.enable", "true")
+	expect("traefik.docker.network", r.config.Network)
+	expect("traefik.http.services."+name+".loadbalancer.server.port", strconv.Itoa(r.config.ContainerPort))
+
+	if v, ok := found["traefik.http.routers."+name+".entrypoints"]; ok {
+		r.config.EntryPoints = splitList(v)
+	} else {
+		r.issuef(leaf.Line, "missing label %q", "traefik.http.routers."+name+".entrypoints")
+	}
+	if v, ok := found["traefik.http.routers."+name+".tls.certresolver"]; ok {
+		r.config.CertResolver = v
+	} else {
+		r.issuef(leaf.Line, "missing label %q", "traefik.http.routers."+name+".tls.certresolver")
+	}
+
+	rule, ok := found["traefik.http.routers."+name+".rule"]
+	if !ok {
+		r.issuef(leaf.Line, "missing router rule")
+		return
+	}
+	hosts, noWWW, prefix, err := parseRouterRule(rule)
+	if err != nil {
+		r.issuef(leaf.Line, "%v", err)
+		return
+	}
+	r.config.Domain, r.config.Subdomain, _ = splitHost(hosts[0])
+	r.config.Aliases = hosts[1:]
+	r.config.NoWWW = noWWW
+	r.config.PathPrefix = prefix
+
+	if v, ok := found["traefik.http.routers."+name+".priority"]; ok {
+		priority, err := strconv.Atoi(v)
+		if err != nil {
+			r.issuef(leaf.Line, "router priority %q is not a number", v)
+		}
+		r.config.Priority = priority
+	}
+
+	r.config.readHealthLabels(found)
+	r.config.readMiddlewares(found)
+	want := map[string]string{}
+	for _, l := range append(r.config.healthLabels(), r.config.middlewareLabels()...) {
+		want[l.Key] = nixStringText(l.Value)
+		if got, ok := found[l.Key]; !ok {
+			r.issuef(leaf.Line, "missing label %q", l.Key)
+		} else if got != want[l.Key] {
+			r.issuef(leaf.Line, "label %q is %q, expected %q", l.Key, got, want[l.Key])
+		}
+	}
+	for key := range found {
+		if _, ok := want[key]; !ok && (strings.HasPrefix(key, "traefik.http.middlewares.") ||
+			strings.HasPrefix(key, "traefik.http.services."+name+".loadbalancer.healthcheck.")) {
+			r.issuef(leaf.Line, "unrecognized label %q", key)
+		}
+	}
+	middlewares := found["traefik.http.routers."+name+".middlewares"]
+	if want := strings.Join(r.config.routerMiddlewares(), ","); middlewares != want {
+		r.issuef(leaf.Line, "router middlewares are %q, expected %q", middlewares, want)
+	}
+}
+
+// parseRouterRule reads a rule built by routerRule back into its hosts,
+// primary first and without the www. aliases, whether those aliases were left
+// out, and the path prefix if there is one.
+func parseRouterRule(rule string) (hosts []string, noWWW bool, prefix string, err error) {
+	hostPart := rule
+	if m := pathPrefixRulePattern.FindStringSubmatch(rule); m != nil {
+		hostPart, prefix = m[1], m[2]
+		if strings.HasPrefix(hostPart, "(") && strings.HasSuffix(hostPart, ")") {
+			hostPart = hostPart[1 : len(hostPart)-1]
+		}
+	}
+	hosts, noWWW, err = parseHostRule(hostPart)
+	return hosts, noWWW, prefix, err
+}
+
+// parseHostRule reads a Host() disjunction as built by hostRule.
+func parseHostRule(rule string) (hosts []string, noWWW bool, err error) {
+	parts := strings.Split(rule, " || ")
+	matched := make([]string, 0, len(parts))
+	for _, part := range parts {
+		m := hostMatcherPattern.FindStringSubmatch(strings.TrimSpace(part))
+		if m == nil {
+			return nil, false, fmt.Errorf("router rule %q is not a Host() disjunction", rule)
+		}
+		matched = append(matched, m[1])
+	}
+
+	noWWW = len(matched) < 2 || matched[1] != "www."+matched[0]
+	step := 2
+	if noWWW {
+		step = 1
+	}
+	for i := 0; i < len(matched); i += step {
+		if !noWWW && (i+1 >= len(matched) || matched[i+1] != "www."+matched[i]) {
+			return nil, false, fmt.Errorf("router rule %q mixes hosts with and without a www. alias", rule)
+		}
+		hosts = append(hosts, matched[i])
+	}
+	return hosts, noWWW, nil
+}
+
+// splitHost splits a hostname into domain and subdomain at the default domain
+// from .rollout.yaml, when the host is under it. A longer host that isn't is
+// ambiguous (app.example.co.uk could be under example.co.uk or co.uk): it is
+// taken whole as the domain, and ok is false.
+func splitHost(host string) (domain, subdomain string, ok bool) {
+	if d := settings.Domain; d != "" && strings.HasSuffix(strings.ToLower(host), "."+strings.ToLower(d)) {
+		return host[len(host)-len(d):], host[:len(host)-len(d)-1], true
+	}
+	return host, "", strings.Count(host, ".") <= 1
+}
+
+func (r *appReader) checkConsistency() {
+	c := r.config
+
+	if c.Image == "" {
+		r.issues = append(r.issues, "no image found")
+	}
+	if c.HostPort == 0 && !c.Internal {
+		r.issues = append(r.issues, "no port binding found")
+	}
+	if c.Network == "" && !c.Internal {
+		r.issues = append(r.issues, "no network found")
+	}
+	switch {
+	case isPinned(c.Image):
+		if r.pullImage != "" {
+			r.issues = append(r.issues, fmt.Sprintf("image is pinned to a digest but ExecStartPre still pulls %q", r.pullImage))
+		}
+	case r.pullImage == "":
+		r.issues = append(r.issues, "no docker pull ExecStartPre found")
+	case r.pullImage != c.Image:
+		r.issues = append(r.issues, fmt.Sprintf("ExecStartPre pulls %q but the image is %q", r.pullImage, c.Image))
+	}
+
+	wantEnv := formatNixPath([]string{"config", "age", "secrets", c.Name, "path"})
+	wantAge := "./" + c.Name + ".age"
+	switch {
+	case r.envFile == "" && r.ageFile == "":
+		c.HasSecrets = false
+	case r.envFile == wantEnv && r.ageFile == wantAge:
+		c.HasSecrets = true
+	case r.envFile == "":
+		r.issues = append(r.issues, "age secret declared but not used in environmentFiles")
+	case r.ageFile == "":
+		r.issues = append(r.issues, "environmentFiles references a secret without an age.secrets declaration")
+	default:
+		r.issues = append(r.issues, fmt.Sprintf("secret wiring %s / %s does not match the app name", r.envFile, r.ageFile))
+	}
+	if len(c.Services) > 0 && !c.HasSecrets {
+		r.issues = append(r.issues, "companion services need the app secret for their connection URLs")
+	}
+
+	if want := c.extraOptions(); strings.Join(r.extraOptions, "\n") != strings.Join(want, "\n") {
+		r.issues = append(r.issues, fmt.Sprintf("extraOptions are %q, expected %q", r.extraOptions, want))
+	}
+	c.applyHealthDefaults()
+
+	if want := c.containerNetworks(); (c.Network != "" || c.Internal) && strings.Join(r.networks, " ") != strings.Join(want, " ") {
+		r.issues = append(r.issues, fmt.Sprintf("container networks are %q, expected %q", r.networks, want))
+	}
+	if want := c.dependsOn(); strings.Join(r.dependsOn, " ") != strings.Join(want, " ") {
+		r.issues = append(r.issues, fmt.Sprintf("dependsOn is %q, expected %q", r.dependsOn, want))
+	}
+	want := c.auxiliaryLeaves()
+	for _, key := range sortedEnvKeys(want) {
+		if got, ok := r.auxiliary[key]; !ok {
+			r.issues = append(r.issues, "missing "+key)
+		} else if got != want[key] {
+			r.issues = append(r.issues, fmt.Sprintf("%s is %s, expected %s", key, got, want[key]))
+		}
+	}
+	for _, key := range sortedEnvKeys(r.auxiliary) {
+		if _, ok := want[key]; !ok {
+			r.issues = append(r.issues, "unrecognized attribute "+key)
+		}
+	}
+
+	wantAuth := "./" + basicAuthSecretName(c.Name) + ".age"
+	switch {
+	case c.BasicAuth && r.authFile != wantAuth:
+		r.issues = append(r.issues, fmt.Sprintf("basic auth is enabled but age.secrets.%s.file is not %s", basicAuthSecretName(c.Name), wantAuth))
+	case c.BasicAuth && r.authOwner != "traefik":
+		r.issues = append(r.issues, fmt.Sprintf("age.secrets.%s must be owned by traefik", basicAuthSecretName(c.Name)))
+	case !c.BasicAuth && (r.authFile != "" || r.authOwner != ""):
+		r.issues = append(r.issues, fmt.Sprintf("age.secrets.%s is declared but no basic auth middleware uses it", basicAuthSecretName(c.Name)))
+	}
+}
+
+func (r *appReader) stringValue(leaf nixLeaf) (string, bool) {
+	if leaf.Value.Kind != nixString {
+		r.issuef(leaf.Line, "%s must be a string, found %s", formatNixPath(leaf.Path), leaf.Value.Kind)
+		return "", false
+	}
+	return leaf.Value.Str, true
+}
+
+func (r *appReader) pathValue(leaf nixLeaf) (string, bool) {
+	if leaf.Value.Kind != nixPath {
+		r.issuef(leaf.Line, "%s must be a path, found %s", formatNixPath(leaf.Path), leaf.Value.Kind)
+		return "", false
+	}
+	return leaf.Value.Str, true
+}
+
+func (r *appReader) stringList(leaf nixLeaf) ([]string, bool) {
+	if leaf.Value.Kind != nixList {
+		r.issuef(leaf.Line, "%s must be a list, found %s", formatNixPath(leaf.Path), leaf.Value.Kind)
+		return nil, false
+	}
+	out := make([]string, 0, len(leaf.Value.Items))
+	for _, item := range leaf.Value.Items {
+		if item.Kind != nixString {
+			r.issuef(item.Line, "%s must only contain strings, found %s", formatNixPath(leaf.Path), item.Kind)
+			return nil, false
+		}
+		out = append(out, item.Str)
+	}
+	return out, true
+}
+
+// nixLeafKey is formatNixPath for paths that may hold segments already
+// quoted by nixQuote, as generated trees do.
+func nixLeafKey(path []string) string {
+	plain := make([]string, len(path))
+	for i, p := range path {
+		if len(p) >= 2 && strings.HasPrefix(p, `"`) && strings.HasSuffix(p, `"`) {
+			p = p[1 : len(p)-1]
+		}
+		plain[i] = p
+	}
+	return formatNixPath(plain)
+}
+
+// nixValueText renders a value for comparison, with strings as parseNix
+// reads them back so generated and parsed trees compare equal.
+func nixValueText(n *nixNode) string {
+	var b strings.Builder
+	switch n.Kind {
+	case nixString:
+		b.WriteString(strconv.Quote(nixStringText(n)))
+	case nixList:
+		b.WriteString("[")
+		for _, item := range n.Items {
+			b.WriteString(" " + nixValueText(item))
+		}
+		b.WriteString(" ]")
+	case nixAttrs:
+		b.WriteString("{")
+		for _, attr := range n.Attrs {
+			b.WriteString(" " + nixLeafKey(attr.Path) + " = " + nixValueText(attr.Value) + ";")
+		}
+		b.WriteString(" }")
+	case nixSelect, nixApply:
+		b.WriteString(nixLeafKey(n.Path))
+		for _, arg := range n.Items {
+			b.WriteString(" " + nixValueText(arg))
+		}
+	default:
+		b.WriteString(n.Str)
+	}
+	return b.String()
+}
+
+// formatNixPath renders an attribute path, quoting segments that are not
+// plain identifiers.
+func formatNixPath(path []string) string {
+	parts := make([]string, len(path))
+	for i, p := range path {
+		if isPlainNixIdent(p) {
+			parts[i] = p
+		} else {
+			parts[i] = nixQuote(p)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+var nixKeywords = map[string]bool{
+	"assert": true, "else": true, "if": true, "in": true, "inherit": true,
+	"let": true, "or": true, "rec": true, "then": true, "with": true,
+}
+
+func isPlainNixIdent(s string) bool {
+	if nixKeywords[s] {
+		return false
+	}
+	if s == "" || !(s[0] == '_' || (s[0] >= 'a' && s[0] <= 'z') || (s[0] >= 'A' && s[0] <= 'Z')) {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isNixIdentChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
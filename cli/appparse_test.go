@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testApps covers the shapes of app file Generate can emit. Their output is
+// kept under testdata/apps; see TestGenerateGolden to update them.
+var testApps = []NixAppConfig{
+	{
+		Name:          "site",
+		Image:         "kabilan108/site:latest",
+		ContainerPort: 3000,
+		Domain:        "example.com",
+		Network:       "traefik",
+		HostPort:      8001,
+		EntryPoints:   []string{"websecure"},
+		CertResolver:  "letsencrypt",
+	},
+	{
+		Name:            "tools",
+		Image:           "ghcr.io/kabilan108/tools:main@" + testDigest,
+		ContainerPort:   8080,
+		Domain:          "example.com",
+		Subdomain:       "tools",
+		Aliases:         []string{"tools.example.org"},
+		NoWWW:           true,
+		PathPrefix:      "/api",
+		StripPrefix:     true,
+		Priority:        1004,
+		BasicAuth:       true,
+		AllowIPs:        []string{"10.0.0.0/8"},
+		RateLimit:       "100/s",
+		SecurityHeaders: true,
+		Compress:        true,
+		HealthPath:      "/healthz",
+		HealthCmd:       "curl -f http://localhost:8080/healthz",
+		HealthInterval:  "10s",
+		HealthTimeout:   "3s",
+		HealthRetries:   5,
+		Memory:          "512m",
+		CPUs:            "1.5",
+		Restart:         "always",
+		User:            "1000:1000",
+		ReadOnly:        true,
+		Tmpfs:           []string{"/tmp"},
+		CapDrop:         []string{"ALL"},
+		Network:         "traefik",
+		HasSecrets:      true,
+		HostPort:        8002,
+		Mounts:          []string{"/srv/tools:/data:rw", "cache:/cache:ro"},
+		EntryPoints:     []string{"websecure"},
+		CertResolver:    "letsencrypt",
+		Environment:     map[string]string{"LOG_LEVEL": "info", "GREETING": `say "hi", ${USER}`},
+	},
+	{
+		Name:          "blog",
+		Image:         "kabilan108/blog:1.0",
+		ContainerPort: 4000,
+		Domain:        "example.com",
+		Subdomain:     "blog",
+		Services:      []string{"postgres", "redis"},
+		JoinNetworks:  []string{"shared"},
+		Network:       "traefik",
+		HasSecrets:    true,
+		HostPort:      8003,
+		EntryPoints:   []string{"websecure"},
+		CertResolver:  "letsencrypt",
+	},
+	{
+		Name:          "worker",
+		Image:         "kabilan108/worker:latest",
+		ContainerPort: 9000,
+		Internal:      true,
+		JoinNetworks:  []string{"shared"},
+		HasSecrets:    true,
+	},
+}
+
+// useDomain makes splitHost split hosts at domain, as settings from
+// .rollout.yaml would.
+func useDomain(t *testing.T, domain string) {
+	t.Helper()
+	saved := settings
+	t.Cleanup(func() { settings = saved })
+	settings.Domain = domain
+}
+
+// normalizedApp treats empty slices and maps as unset, since the parser
+// doesn't distinguish them.
+func normalizedApp(c NixAppConfig) NixAppConfig {
+	v := reflect.ValueOf(&c).Elem()
+	for i := range v.NumField() {
+		if f := v.Field(i); (f.Kind() == reflect.Slice || f.Kind() == reflect.Map) && f.Len() == 0 {
+			f.SetZero()
+		}
+	}
+	return c
+}
+
+func TestParseNixAppRoundTrip(t *testing.T) {
+	useDomain(t, "example.com")
+	for _, app := range testApps {
+		t.Run(app.Name, func(t *testing.T) {
+			got, err := parseNixApp(app.Generate())
+			if err != nil {
+				t.Fatal(err)
+			}
+			// an internal app has no labels or port binding to hold its
+			// container port and traefik settings
+			want := app
+			if want.Internal {
+				want.ContainerPort, want.EntryPoints, want.CertResolver = 0, nil, ""
+			}
+			if got, want := normalizedApp(*got), normalizedApp(want); !reflect.DeepEqual(got, want) {
+				t.Errorf("parsed config differs\n got: %+v\nwant: %+v", got, want)
+			}
+		})
+	}
+}
+
+// TestParseAppFileGolden reads the files under testdata/apps, which must
+// parse cleanly and regenerate byte for byte.
+func TestParseAppFileGolden(t *testing.T) {
+	useDomain(t, "example.com")
+	paths, err := filepath.Glob(filepath.Join("testdata", "apps", "*.nix"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no files under testdata/apps")
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config, err := parseAppFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := config.Generate(); got != string(want) {
+				t.Errorf("regenerated file differs\n got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestParseNixAppHandEdits(t *testing.T) {
+	useDomain(t, "example.com")
+	src := testApps[0].Generate()
+
+	tests := []struct {
+		name  string
+		edit  func(string) string
+		issue string
+	}{
+		{
+			name:  "unknown attribute",
+			edit:  func(s string) string { return strings.Replace(s, "  image = ", "  autoStart = false;\n  image = ", 1) },
+			issue: `unrecognized container attribute "autoStart"`,
+		},
+		{
+			name: "pull of another image",
+			edit: func(s string) string {
+				return strings.Replace(s, "docker pull kabilan108/site", "docker pull kabilan108/other", 1)
+			},
+			issue: "ExecStartPre pulls",
+		},
+		{
+			name:  "port binding removed",
+			edit:  func(s string) string { return strings.Replace(s, `"127.0.0.1:8001:3000"`, "", 1) },
+			issue: "no port binding found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edited := tt.edit(src)
+			if edited == src {
+				t.Fatal("edit did not apply")
+			}
+			config, err := parseNixApp(edited)
+			var perr *AppParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("error = %v, want an *AppParseError", err)
+			}
+			if config == nil {
+				t.Fatal("config = nil, want the partial config")
+			}
+			if !strings.Contains(perr.Error(), tt.issue) {
+				t.Errorf("issues = %q, want one containing %q", perr.Issues, tt.issue)
+			}
+		})
+	}
+}
+
+func TestParseNixAppSyntaxError(t *testing.T) {
+	config, err := parseNixApp("{ config, ... }:\n{\n  virtualisation = {\n")
+	if err == nil {
+		t.Fatal("error = nil, want a syntax error")
+	}
+	if config != nil {
+		t.Errorf("config = %+v, want nil", config)
+	}
+}
+
+func TestParseAppFileChecksName(t *testing.T) {
+	useDomain(t, "example.com")
+	path := filepath.Join(t.TempDir(), "other.nix")
+	if err := os.WriteFile(path, []byte(testApps[0].Generate()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := parseAppFile(path)
+	var perr *AppParseError
+	if !errors.As(err, &perr) || !strings.Contains(perr.Error(), `named "site" but the file is other.nix`) {
+		t.Fatalf("error = %v, want a name mismatch", err)
+	}
+}
+
+func TestSplitHost(t *testing.T) {
+	tests := []struct {
+		domain, host        string
+		wantDomain, wantSub string
+		wantOK              bool
+	}{
+		{"", "example.com", "example.com", "", true},
+		{"", "app.example.com", "app.example.com", "", false},
+		{"example.com", "app.example.com", "example.com", "app", true},
+		{"example.com", "a.b.example.com", "example.com", "a.b", true},
+		{"example.com", "App.Example.COM", "Example.COM", "App", true},
+		{"example.com", "example.com", "example.com", "", true},
+		{"example.com", "app.example.org", "app.example.org", "", false},
+	}
+	for _, tt := range tests {
+		useDomain(t, tt.domain)
+		domain, sub, ok := splitHost(tt.host)
+		if domain != tt.wantDomain || sub != tt.wantSub || ok != tt.wantOK {
+			t.Errorf("splitHost(%q) with domain %q = %q, %q, %v, want %q, %q, %v",
+				tt.host, tt.domain, domain, sub, ok, tt.wantDomain, tt.wantSub, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// Finding is a single problem reported by `rollout check`.
+type Finding struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	App      string `json:"app,omitempty"`
+	File     string `json:"file,omitempty"`
+	Message  string `json:"message"`
+}
+
+// CheckReport is what `rollout check --output json` prints: how each app is
+// exposed, and the findings.
+type CheckReport struct {
+	Apps     []AppExposure `json:"apps"`
+	Findings []Finding     `json:"findings"`
+}
+
+// AppExposure says whether an app is reachable through traefik ("public") or
+// only from other containers ("internal").
+type AppExposure struct {
+	Name     string `json:"name"`
+	Exposure string `json:"exposure"`
+}
+
+func newCheckCmd(configDir *string) *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "lint the config directory for conflicts and dangling references",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			findings, err := checkConfigDir(*configDir)
+			if err != nil {
+				fmt.Println(errorStyle.Render("✗ Failed to check config: " + err.Error()))
+				os.Exit(1)
+			}
+
+			switch output {
+			case "json":
+				report := CheckReport{Apps: appExposures(*configDir), Findings: findings}
+				data, err := json.MarshalIndent(report, "", "  ")
+				if err != nil {
+					fmt.Println(errorStyle.Render("✗ Failed to encode findings: " + err.Error()))
+					os.Exit(1)
+				}
+				fmt.Println(string(data))
+			case "text":
+				printExposure(appExposures(*configDir))
+				printFindings(findings)
+			default:
+				fmt.Println(errorStyle.Render("✗ Unknown output format: " + output))
+				fmt.Println(mutedStyle.Render("Use one of: text, json"))
+				os.Exit(1)
+			}
+
+			for _, f := range findings {
+				if f.Severity == severityError {
+					os.Exit(1)
+				}
+			}
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "text", "output format (text or json)")
+
+	return cmd
+}
+
+// appScan is everything check needs from one app file, read from the raw
+// syntax tree so hand-edited files are still covered.
+type appScan struct {
+	Name       string
+	File       string
+	Config     *NixAppConfig
+	Routers    []string
+	Routes     []string // host plus path prefix, as matched by a router rule
+	SecretRefs []string
+	AgeFiles   []string
+}
+
+var (
+	routerLabelPattern = regexp.MustCompile(`^traefik\.http\.routers\.([^.]+)\.`)
+	hostRulePattern    = regexp.MustCompile("Host\\(`([^`]+)`\\)")
+	prefixRulePattern  = regexp.MustCompile("PathPrefix\\(`([^`]+)`\\)")
+)
+
+func scanAppFile(path string) (*appScan, []Finding) {
+	name := strings.TrimSuffix(filepath.Base(path), ".nix")
+	scan := &appScan{Name: name, File: path}
+	var findings []Finding
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, []Finding{{severityError, "unreadable", name, path, err.Error()}}
+	}
+	root, err := parseNix(string(content))
+	if err != nil {
+		return nil, []Finding{{severityError, "syntax-error", name, path, err.Error()}}
+	}
+
+	config, err := parseAppFile(path)
+	var perr *AppParseError
+	if errors.As(err, &perr) {
+		for _, issue := range perr.Issues {
+			findings = append(findings, Finding{severityWarning, "hand-edited", name, path, issue})
+		}
+	}
+	scan.Config = config
+
+	walkNix(nil, root, func(path []string, value *nixNode) {
+		if len(path) == 0 {
+			return
+		}
+		last := path[len(path)-1]
+		if m := routerLabelPattern.FindStringSubmatch(last); m != nil && value.Kind == nixString {
+			if !containsString(scan.Routers, m[1]) {
+				scan.Routers = append(scan.Routers, m[1])
+			}
+			if strings.HasSuffix(last, ".rule") {
+				prefix := ""
+				if m := prefixRulePattern.FindStringSubmatch(value.Str); m != nil {
+					prefix = m[1]
+				}
+				for _, h := range hostRulePattern.FindAllStringSubmatch(value.Str, -1) {
+					scan.Routes = append(scan.Routes, routeKey(h[1], prefix))
+				}
+			}
+		}
+		if value.Kind == nixSelect && len(value.Path) >= 4 && hasPathPrefix(value.Path, "config", "age", "secrets") {
+			scan.SecretRefs = append(scan.SecretRefs, value.Path[3])
+		}
+		if len(path) == 4 && hasPathPrefix(path, "age", "secrets") && path[3] == "file" && value.Kind == nixPath {
+			scan.AgeFiles = append(scan.AgeFiles, value.Str)
+		}
+	})
+
+	return scan, findings
+}
+
+// walkNix calls fn for every binding and list item under node, with the full
+// attribute path leading to it.
+func walkNix(prefix []string, node *nixNode, fn func(path []string, value *nixNode)) {
+	switch node.Kind {
+	case nixAttrs:
+		for _, b := range node.Attrs {
+			path := append(append([]string{}, prefix...), b.Path...)
+			fn(path, b.Value)
+			walkNix(path, b.Value, fn)
+		}
+	case nixList:
+		for _, item := range node.Items {
+			fn(prefix, item)
+			walkNix(prefix, item, fn)
+		}
+	}
+}
+
+// routeKey identifies what a router claims: a hostname and an optional path
+// prefix, rendered like the rule that matches it.
+func routeKey(host, prefix string) string {
+	key := fmt.Sprintf("Host(`%s`)", strings.ToLower(host))
+	if prefix != "" {
+		key += fmt.Sprintf(" && PathPrefix(`%s`)", prefix)
+	}
+	return key
+}
+
+// appRoutes lists the routes a generated app claims.
+func appRoutes(c *NixAppConfig) []string {
+	var routes []string
+	for _, h := range c.Hosts() {
+		routes = append(routes, routeKey(h, c.PathPrefix))
+	}
+	return routes
+}
+
+// findRouteConflicts reports routes of c that another app in the config dir
+// already claims.
+func findRouteConflicts(configDir string, c *NixAppConfig) ([]error, error) {
+	paths, err := filepath.Glob(filepath.Join(configDir, "apps", "*.nix"))
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := map[string]bool{}
+	for _, r := range appRoutes(c) {
+		claimed[r] = true
+	}
+
+	var errs []error
+	for _, path := range paths {
+		other := strings.TrimSuffix(filepath.Base(path), ".nix")
+		if other == c.Name {
+			continue
+		}
+		// hand-edited files still claim whatever hosts could be read
+		config, _ := parseAppFile(path)
+		if config == nil || config.Domain == "" {
+			continue
+		}
+		for _, r := range appRoutes(config) {
+			if claimed[r] {
+				errs = append(errs, fmt.Errorf("%s is already claimed by %s", r, other))
+			}
+		}
+	}
+	return errs, nil
+}
+
+// mustHaveNoRouteConflicts exits if c would take over another app's route.
+func mustHaveNoRouteConflicts(configDir string, c *NixAppConfig) {
+	errs, err := findRouteConflicts(configDir, c)
+	if err != nil {
+		fmt.Println(errorStyle.Render("✗ Failed to read existing apps: " + err.Error()))
+		os.Exit(1)
+	}
+	exitOnValidationErrors(errs)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// checkConfigDir runs every check over the config dir and returns the
+// findings sorted by app.
+func checkConfigDir(configDir string) ([]Finding, error) {
+	appsDir := filepath.Join(configDir, "apps")
+	registryPath := filepath.Join(configDir, "ports.json")
+
+	registry, err := loadPortRegistry(configDir)
+	if err != nil {
+		return nil, err
+	}
+	secretEntries, err := loadSecretEntries(secretsNixPath())
+	if err != nil {
+		return nil, err
+	}
+	schemas, err := loadEnvSchemas(configDir)
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(appsDir, "*.nix"))
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []Finding{}
+	var scans []*appScan
+	for _, path := range paths {
+		scan, fs := scanAppFile(path)
+		findings = append(findings, fs...)
+		if scan != nil {
+			scans = append(scans, scan)
+		}
+	}
+
+	// conflicts between apps
+	portOwners := map[int][]string{}
+	routerOwners := map[string][]string{}
+	routeOwners := map[string][]string{}
+	for _, scan := range scans {
+		if scan.Config != nil && scan.Config.HostPort != 0 {
+			portOwners[scan.Config.HostPort] = append(portOwners[scan.Config.HostPort], scan.Name)
+		}
+		for _, r := range scan.Routers {
+			routerOwners[r] = append(routerOwners[r], scan.Name)
+		}
+		seen := map[string]bool{}
+		for _, r := range scan.Routes {
+			if !seen[r] {
+				routeOwners[r] = append(routeOwners[r], scan.Name)
+				seen[r] = true
+			}
+		}
+	}
+	for port, owners := range portOwners {
+		if len(owners) > 1 {
+			findings = append(findings, Finding{severityError, "duplicate-host-port", "", "",
+				fmt.Sprintf("host port %d is bound by %s", port, strings.Join(owners, ", "))})
+		}
+	}
+	for router, owners := range routerOwners {
+		if len(owners) > 1 {
+			findings = append(findings, Finding{severityError, "duplicate-router", "", "",
+				fmt.Sprintf("router %q is defined by %s", router, strings.Join(owners, ", "))})
+		}
+	}
+	for route, owners := range routeOwners {
+		if len(owners) > 1 {
+			findings = append(findings, Finding{severityError, "duplicate-host", "", "",
+				fmt.Sprintf("%s is claimed by %s", route, strings.Join(owners, ", "))})
+		}
+	}
+
+	// app files against ports.json
+	files := map[string]*appScan{}
+	for _, scan := range scans {
+		files[scan.Name] = scan
+		allocated, ok := registry.Allocations[scan.Name]
+		internal := scan.Config != nil && scan.Config.Internal
+		switch {
+		case internal && ok:
+			findings = append(findings, Finding{severityWarning, "internal-port-entry", scan.Name, scan.File,
+				fmt.Sprintf("app is internal but ports.json still allocates port %d to it", allocated)})
+		case internal:
+			// nothing is bound, so there is nothing to allocate
+		case !ok:
+			findings = append(findings, Finding{severityError, "missing-port-entry", scan.Name, scan.File,
+				"app has no allocation in ports.json"})
+		case scan.Config != nil && scan.Config.HostPort != 0 && scan.Config.HostPort != allocated:
+			findings = append(findings, Finding{severityError, "port-mismatch", scan.Name, scan.File,
+				fmt.Sprintf("app binds host port %d but ports.json allocates %d", scan.Config.HostPort, allocated)})
+		}
+	}
+	for name, port := range registry.Allocations {
+		if _, ok := files[name]; !ok && !fileExists(filepath.Join(appsDir, name+".nix")) {
+			findings = append(findings, Finding{severityError, "orphan-port-entry", name, registryPath,
+				fmt.Sprintf("port %d is allocated but apps/%s.nix does not exist", port, name)})
+		}
+		if port < PortRangeStart || port > PortRangeEnd {
+			findings = append(findings, Finding{severityError, "port-out-of-range", name, registryPath,
+				fmt.Sprintf("port %d is outside %d-%d", port, PortRangeStart, PortRangeEnd)})
+		}
+	}
+	for _, scan := range scans {
+		if _, ok := registry.Allocations[scan.Name]; ok || scan.Config == nil || scan.Config.HostPort == 0 {
+			continue
+		}
+		if port := scan.Config.HostPort; port < PortRangeStart || port > PortRangeEnd {
+			findings = append(findings, Finding{severityError, "port-out-of-range", scan.Name, scan.File,
+				fmt.Sprintf("host port %d is outside %d-%d", port, PortRangeStart, PortRangeEnd)})
+		}
+	}
+
+	// plain-text variables that look like they belong in the secret
+	for _, scan := range scans {
+		if scan.Config == nil {
+			continue
+		}
+		for _, key := range sortedEnvKeys(scan.Config.Environment) {
+			if looksLikeSecret(key) {
+				findings = append(findings, Finding{severityWarning, "plaintext-secret", scan.Name, scan.File,
+					fmt.Sprintf("environment variable %s looks like a secret but is stored in plain text; move it to the agenix secret", key)})
+			}
+		}
+	}
+
+	// secrets referenced by apps; flagged keeps the secrets.nix entries of
+	// what is reported here from being reported again below
+	flagged := map[string]bool{}
+	for _, scan := range scans {
+		missing := map[string]bool{}
+		for _, ref := range scan.SecretRefs {
+			agePath := filepath.Join(appsDir, ref+".age")
+			if !fileExists(agePath) {
+				missing["./"+ref+".age"] = true
+				flagged[ageEntryPath(configDir, ref)] = true
+				findings = append(findings, Finding{severityError, "missing-secret-file", scan.Name, scan.File,
+					fmt.Sprintf("references config.age.secrets.%s but %s does not exist", ref, agePath)})
+			}
+			if entry := ageEntryPath(configDir, ref); !secretEntries[entry] {
+				missing[entry] = true
+				flagged[entry] = true
+				findings = append(findings, Finding{severityError, "missing-secret-entry", scan.Name, scan.File,
+					fmt.Sprintf("references config.age.secrets.%s but secrets.nix has no %q entry", ref, entry)})
+			}
+		}
+		for _, file := range scan.AgeFiles {
+			entry := ageEntryPath(configDir, strings.TrimSuffix(filepath.Base(file), ".age"))
+			if !missing[file] && !fileExists(filepath.Join(appsDir, file)) {
+				flagged[entry] = true
+				findings = append(findings, Finding{severityError, "missing-secret-file", scan.Name, scan.File,
+					fmt.Sprintf("age.secrets file %s does not exist", file)})
+			}
+			if !missing[entry] && !secretEntries[entry] {
+				missing[entry] = true
+				flagged[entry] = true
+				findings = append(findings, Finding{severityError, "missing-secret-entry", scan.Name, scan.File,
+					fmt.Sprintf("declares age.secrets file %s but secrets.nix has no %q entry", file, entry)})
+			}
+		}
+	}
+
+	// secrets.nix entries against the .age files, which agenix needs both of
+	agePaths, err := filepath.Glob(filepath.Join(appsDir, "*.age"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range agePaths {
+		name := strings.TrimSuffix(filepath.Base(path), ".age")
+		if entry := ageEntryPath(configDir, name); !secretEntries[entry] && !flagged[entry] {
+			findings = append(findings, Finding{severityError, "orphan-secret-file", name, path,
+				fmt.Sprintf("secrets.nix has no %q entry for this file", entry)})
+		}
+	}
+	for entry := range secretEntries {
+		if flagged[entry] || fileExists(secretFilePath(entry)) {
+			continue
+		}
+		app := strings.TrimSuffix(filepath.Base(entry), ".age")
+		if entry != ageEntryPath(configDir, app) {
+			app = ""
+		}
+		findings = append(findings, Finding{severityError, "orphan-secret-entry", app, secretsNixPath(),
+			fmt.Sprintf("secrets.nix lists %q but %s does not exist", entry, secretFilePath(entry))})
+	}
+
+	// declared env keys against the app secrets, where they can be decrypted
+	_, identityErr := identityPaths()
+	schemaPath := filepath.Join(configDir, "env-schema.json")
+	declared := make([]string, 0, len(schemas.Apps))
+	for name := range schemas.Apps {
+		declared = append(declared, name)
+	}
+	sort.Strings(declared)
+	for _, name := range declared {
+		schema := schemas.Apps[name]
+		scan, ok := files[name]
+		if !ok {
+			findings = append(findings, Finding{severityWarning, "orphan-env-schema", name, schemaPath,
+				fmt.Sprintf("env keys are declared but apps/%s.nix does not exist", name)})
+			continue
+		}
+		var provided []string
+		if scan.Config != nil {
+			provided = companionEnvKeys(scan.Config.Services)
+		}
+		entry := ageEntryPath(configDir, name)
+		switch {
+		case !fileExists(secretFilePath(entry)):
+			if missing := schema.missingEnv(nil, provided...); len(missing) > 0 {
+				findings = append(findings, Finding{severityError, "missing-required-env", name, schemaPath,
+					fmt.Sprintf("requires %s but the app has no secret", strings.Join(missing, ", "))})
+			}
+		case identityErr != nil:
+			// nothing to decrypt with, as in CI; the secret goes unchecked
+		default:
+			content, err := decryptSecretData(entry)
+			if err != nil {
+				findings = append(findings, Finding{severityWarning, "unverified-env", name, schemaPath,
+					"can't check the secret's keys: " + err.Error()})
+				continue
+			}
+			env, err := parseEnvFile(string(content))
+			if err != nil {
+				findings = append(findings, Finding{severityError, "malformed-secret", name, secretFilePath(entry),
+					"secret is not an env file: " + err.Error()})
+				continue
+			}
+			if missing := schema.missingEnv(env); len(missing) > 0 {
+				findings = append(findings, Finding{severityError, "missing-required-env", name, secretFilePath(entry),
+					fmt.Sprintf("secret is missing required %s", strings.Join(missing, ", "))})
+			}
+			if undeclared := schema.undeclaredEnv(env, provided...); len(undeclared) > 0 {
+				findings = append(findings, Finding{severityWarning, "undeclared-env", name, secretFilePath(entry),
+					fmt.Sprintf("secret sets %s, which the app doesn't declare", strings.Join(undeclared, ", "))})
+			}
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].App != findings[j].App {
+			return findings[i].App < findings[j].App
+		}
+		if findings[i].Code != findings[j].Code {
+			return findings[i].Code < findings[j].Code
+		}
+		return findings[i].Message < findings[j].Message
+	})
+
+	return findings, nil
+}
+
+// appExposures reads the exposure of every app file, sorted by name.
+// Unparseable files are left out; they are reported as findings.
+func appExposures(configDir string) []AppExposure {
+	apps := []AppExposure{}
+	paths, _ := filepath.Glob(filepath.Join(configDir, "apps", "*.nix"))
+	for _, path := range paths {
+		if config, _ := parseAppFile(path); config != nil {
+			apps = append(apps, AppExposure{config.Name, config.exposure()})
+		}
+	}
+	return apps
+}
+
+// printExposure lists which apps are reachable through traefik and which
+// only from other containers.
+func printExposure(apps []AppExposure) {
+	exposure := map[string][]string{}
+	for _, app := range apps {
+		exposure[app.Exposure] = append(exposure[app.Exposure], app.Name)
+	}
+	for _, kind := range []string{"public", "internal"} {
+		if len(exposure[kind]) > 0 {
+			fmt.Println(mutedStyle.Render(fmt.Sprintf("ℹ️ %d %s: %s", len(exposure[kind]), kind, strings.Join(exposure[kind], ", "))))
+		}
+	}
+}
+
+func printFindings(findings []Finding) {
+	errs, warns := 0, 0
+	for _, f := range findings {
+		where := f.App
+		if where == "" {
+			where = "(global)"
+		}
+		if f.Severity == severityError {
+			errs++
+			fmt.Println(errorStyle.Render("✗ "+where) + " " + f.Message + " " + mutedStyle.Render("["+f.Code+"]"))
+		} else {
+			warns++
+			fmt.Println(promptStyle.Render("⚠️ "+where) + " " + f.Message + " " + mutedStyle.Render("["+f.Code+"]"))
+		}
+	}
+
+	if errs == 0 && warns == 0 {
+		fmt.Println(successStyle.Render("✓ No problems found"))
+		return
+	}
+	fmt.Println(mutedStyle.Render(fmt.Sprintf("%d error(s), %d warning(s)", errs, warns)))
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testConfigDir points settings at a fresh repo with an empty servers/apps
+// directory and returns the config directory.
+func testConfigDir(t *testing.T) string {
+	t.Helper()
+	saved := settings
+	t.Cleanup(func() { settings = saved })
+
+	dir := t.TempDir()
+	settings.RepoRoot = dir
+	settings.ConfigDir = filepath.Join(dir, "servers")
+	settings.Domain = "example.com"
+	if err := os.MkdirAll(filepath.Join(settings.ConfigDir, "apps"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return settings.ConfigDir
+}
+
+func writeApp(t *testing.T, configDir string, app NixAppConfig) {
+	t.Helper()
+	writeTestFile(t, appFilePath(configDir, app.Name), app.Generate(), 0o644)
+}
+
+func writePorts(t *testing.T, configDir string, allocations map[string]int) {
+	t.Helper()
+	registry := &PortRegistry{Allocations: allocations, NextPort: PortRangeStart}
+	resetNextPort(registry)
+	if err := savePortRegistry(registry, configDir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeSecretsNix writes a secrets.nix with an entry for each repo-relative
+// path, all encrypted to the same key.
+func writeSecretsNix(t *testing.T, entries ...string) {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("let\n  admin = \"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIA admin\";\nin\n{\n")
+	for _, entry := range entries {
+		b.WriteString("  " + nixQuote(entry) + ".publicKeys = [ admin ];\n")
+	}
+	b.WriteString("}\n")
+	writeTestFile(t, secretsNixPath(), b.String(), 0o644)
+}
+
+func touchSecret(t *testing.T, configDir, name string) {
+	t.Helper()
+	writeTestFile(t, filepath.Join(configDir, "apps", name+".age"), "age", 0o600)
+}
+
+func TestCheckConfigDir(t *testing.T) {
+	site := testApps[0]
+	site.HostPort = 10001
+	withSecret := site
+	withSecret.HasSecrets = true
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, configDir string)
+		want  []string // app:code
+	}{
+		{
+			name: "clean",
+			setup: func(t *testing.T, configDir string) {
+				writeApp(t, configDir, withSecret)
+				writePorts(t, configDir, map[string]int{"site": 10001})
+				touchSecret(t, configDir, "site")
+				writeSecretsNix(t, "servers/apps/site.age")
+			},
+		},
+		{
+			name: "duplicate host port and host",
+			setup: func(t *testing.T, configDir string) {
+				other := site
+				other.Name = "other"
+				writeApp(t, configDir, site)
+				writeApp(t, configDir, other)
+				writePorts(t, configDir, map[string]int{"site": 10001, "other": 10001})
+			},
+			want: []string{":duplicate-host", ":duplicate-host", ":duplicate-host-port"},
+		},
+		{
+			name: "ports.json out of step",
+			setup: func(t *testing.T, configDir string) {
+				other := site
+				other.Name, other.Subdomain, other.HostPort = "other", "other", 10002
+				writeApp(t, configDir, site)
+				writeApp(t, configDir, other)
+				writePorts(t, configDir, map[string]int{"site": 10005, "gone": 10003, "far": 7000})
+			},
+			want: []string{"far:orphan-port-entry", "far:port-out-of-range", "gone:orphan-port-entry",
+				"other:missing-port-entry", "site:port-mismatch"},
+		},
+		{
+			name: "internal app with a port",
+			setup: func(t *testing.T, configDir string) {
+				writeApp(t, configDir, NixAppConfig{Name: "worker", Image: "worker:latest", Internal: true})
+				writePorts(t, configDir, map[string]int{"worker": 10001})
+			},
+			want: []string{"worker:internal-port-entry"},
+		},
+		{
+			name: "secret referenced but missing",
+			setup: func(t *testing.T, configDir string) {
+				writeApp(t, configDir, withSecret)
+				writePorts(t, configDir, map[string]int{"site": 10001})
+			},
+			want: []string{"site:missing-secret-entry", "site:missing-secret-file"},
+		},
+		{
+			name: "secrets.nix and .age files out of step",
+			setup: func(t *testing.T, configDir string) {
+				writePorts(t, configDir, map[string]int{})
+				touchSecret(t, configDir, "stray")
+				writeSecretsNix(t, "servers/apps/gone.age", "keys/deploy.age")
+			},
+			want: []string{":orphan-secret-entry", "gone:orphan-secret-entry", "stray:orphan-secret-file"},
+		},
+		{
+			name: "plain-text secret",
+			setup: func(t *testing.T, configDir string) {
+				app := site
+				app.Environment = map[string]string{"API_TOKEN": "hunter2", "LOG_LEVEL": "info"}
+				writeApp(t, configDir, app)
+				writePorts(t, configDir, map[string]int{"site": 10001})
+			},
+			want: []string{"site:plaintext-secret"},
+		},
+		{
+			name: "hand-edited and broken files",
+			setup: func(t *testing.T, configDir string) {
+				edited := strings.Replace(site.Generate(), "  image = ", "  autoStart = false;\n  image = ", 1)
+				writeTestFile(t, appFilePath(configDir, "site"), edited, 0o644)
+				writeTestFile(t, appFilePath(configDir, "broken"), "{ virtualisation = ", 0o644)
+				writePorts(t, configDir, map[string]int{"site": 10001, "broken": 10002})
+			},
+			want: []string{"broken:syntax-error", "site:hand-edited"},
+		},
+		{
+			name: "required env without a secret",
+			setup: func(t *testing.T, configDir string) {
+				writeApp(t, configDir, site)
+				writePorts(t, configDir, map[string]int{"site": 10001})
+				schemas := &EnvSchemaRegistry{Apps: map[string]EnvSchema{"site": {Required: []string{"DATABASE_URL"}}}}
+				if err := saveEnvSchemas(schemas, configDir); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"site:missing-required-env"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configDir := testConfigDir(t)
+			tt.setup(t, configDir)
+
+			findings, err := checkConfigDir(configDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range findings {
+				got = append(got, f.App+":"+f.Code)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("findings = %q, want %q\n%+v", got, tt.want, findings)
+			}
+		})
+	}
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newEditCmd(configDir *string) *cobra.Command {
+	var (
+		image          string
+		domain         string
+		subdomain      string
+		port           int
+		network        string
+		addMounts      []string
+		removeMounts   []string
+		env            []string
+		envFrom        string
+		unsetEnv       []string
+		addHosts       []string
+		removeHosts    []string
+		noWWW          bool
+		prefix         string
+		strip          bool
+		priority       int
+		authUser       string
+		noAuth         bool
+		allowIPs       []string
+		removeIPs      []string
+		rateLimit      string
+		headers        bool
+		compress       bool
+		healthPath     string
+		healthCmd      string
+		healthInterval string
+		healthTimeout  string
+		healthRetries  int
+		memory         string
+		cpus           string
+		restart        string
+		user           string
+		readOnly       bool
+		addTmpfs       []string
+		removeTmpfs    []string
+		capDrop        []string
+		removeCapDrop  []string
+		pin            bool
+		unpin          bool
+		internal       bool
+		joinNetworks   []string
+		leaveNetworks  []string
+		dryRun         bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "edit <app>",
+		Short: "change an existing app's settings in place",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			appName := args[0]
+			mustValidateAppArg(appName)
+			filePath := filepath.Join(*configDir, "apps", fmt.Sprintf("%s.nix", appName))
+
+			if !dryRun {
+				lock := mustLockConfigDir(*configDir)
+				defer lock.Unlock()
+			}
+
+			current := mustLoadAppForEdit(*configDir, appName)
+			updated := *current
+
+			flags := cmd.Flags()
+			anyEditFlag := false
+			for _, f := range []string{"image", "domain", "subdomain", "port", "network", "add-mount", "remove-mount", "env", "env-from-file", "unset-env", "add-host", "remove-host", "no-www", "path-prefix", "strip-prefix", "priority",
+				"basic-auth", "no-basic-auth", "allow-ip", "remove-allow-ip", "rate-limit", "security-headers", "compress",
+				"health-path", "health-cmd", "health-interval", "health-timeout", "health-retries",
+				"memory", "cpus", "restart", "user", "read-only", "tmpfs", "remove-tmpfs", "cap-drop", "remove-cap-drop", "pin", "unpin",
+				"internal", "join-network", "leave-network"} {
+				anyEditFlag = anyEditFlag || flags.Changed(f)
+			}
+
+			if !anyEditFlag {
+				cfg, ok, err := RunEditTUI(appConfigFromNix(current, *configDir))
+				if err != nil {
+					fmt.Println(errorStyle.Render("Error: " + err.Error()))
+					os.Exit(1)
+				}
+				if !ok {
+					return
+				}
+				updated.Image = cfg.Image
+				if !current.Internal {
+					updated.Domain = cfg.Domain
+					updated.Subdomain = cfg.Subdomain
+					updated.ContainerPort = cfg.Port
+					updated.Network = cfg.Network
+				}
+				updated.Mounts = cfg.Mounts
+				updated.Environment = cfg.Env
+				updated.HealthPath = cfg.HealthPath
+				updated.HealthCmd = cfg.HealthCmd
+				updated.applyHealthDefaults()
+			} else {
+				if flags.Changed("image") {
+					updated.Image = image
+				}
+				if flags.Changed("domain") {
+					updated.Domain = domain
+				}
+				if flags.Changed("subdomain") {
+					// the file only has the hostname, so the domain to put the
+					// subdomain in front of may have to be spelled out
+					if _, _, ok := splitHost(current.Host()); !ok && !flags.Changed("domain") {
+						fmt.Println(errorStyle.Render("✗ Can't tell which part of " + current.Host() + " is the domain"))
+						fmt.Println(mutedStyle.Render("Pass it with --domain along with --subdomain"))
+						os.Exit(1)
+					}
+					updated.Subdomain = subdomain
+				}
+				if flags.Changed("port") {
+					updated.ContainerPort = port
+				}
+				if flags.Changed("network") {
+					updated.Network = network
+				}
+				if flags.Changed("internal") {
+					updated.Internal = internal
+					if !internal && updated.Network == "" {
+						updated.Network = settings.Network
+					}
+					if !internal && len(updated.EntryPoints) == 0 {
+						updated.EntryPoints = settings.EntryPoints
+						updated.CertResolver = settings.CertResolver
+					}
+				}
+				joined, err := editList(current.JoinNetworks, joinNetworks, leaveNetworks)
+				if err != nil {
+					fmt.Println(errorStyle.Render("✗ " + err.Error()))
+					os.Exit(1)
+				}
+				updated.JoinNetworks = joined
+				mounts, err := editMounts(current.Mounts, addMounts, removeMounts)
+				if err != nil {
+					fmt.Println(errorStyle.Render("✗ " + err.Error()))
+					os.Exit(1)
+				}
+				updated.Mounts = mounts
+				vars, err := editEnv(current.Environment, envFrom, env, unsetEnv)
+				if err != nil {
+					fmt.Println(errorStyle.Render("✗ " + err.Error()))
+					os.Exit(1)
+				}
+				updated.Environment = vars
+				aliases, err := editList(current.Aliases, addHosts, removeHosts)
+				if err != nil {
+					fmt.Println(errorStyle.Render("✗ " + err.Error()))
+					os.Exit(1)
+				}
+				updated.Aliases = aliases
+				if flags.Changed("no-www") {
+					updated.NoWWW = noWWW
+				}
+				if flags.Changed("path-prefix") {
+					updated.PathPrefix = prefix
+					if !flags.Changed("priority") && current.Priority == defaultRouterPriority(current.PathPrefix) {
+						updated.Priority = defaultRouterPriority(prefix)
+					}
+					if prefix == "" {
+						updated.StripPrefix = false
+					}
+				}
+				if flags.Changed("strip-prefix") {
+					updated.StripPrefix = strip
+				}
+				if flags.Changed("priority") {
+					updated.Priority = priority
+				}
+				ips, err := editList(current.AllowIPs, allowIPs, removeIPs)
+				if err != nil {
+					fmt.Println(errorStyle.Render("✗ " + err.Error()))
+					os.Exit(1)
+				}
+				updated.AllowIPs = ips
+				if flags.Changed("rate-limit") {
+					updated.RateLimit = rateLimit
+				}
+				if flags.Changed("security-headers") {
+					updated.SecurityHeaders = headers
+				}
+				if flags.Changed("compress") {
+					updated.Compress = compress
+				}
+				if flags.Changed("health-path") {
+					updated.HealthPath = healthPath
+				}
+				if flags.Changed("health-cmd") {
+					updated.HealthCmd = healthCmd
+				}
+				if flags.Changed("health-interval") {
+					updated.HealthInterval = healthInterval
+				}
+				if flags.Changed("health-timeout") {
+					updated.HealthTimeout = healthTimeout
+				}
+				if flags.Changed("health-retries") {
+					updated.HealthRetries = healthRetries
+				}
+				updated.applyHealthDefaults()
+				if flags.Changed("memory") {
+					updated.Memory = memory
+				}
+				if flags.Changed("cpus") {
+					updated.CPUs = cpus
+				}
+				if flags.Changed("restart") {
+					updated.Restart = restart
+				}
+				if flags.Changed("user") {
+					updated.User = user
+				}
+				if flags.Changed("read-only") {
+					updated.ReadOnly = readOnly
+				}
+				tmpfs, err := editList(current.Tmpfs, addTmpfs, removeTmpfs)
+				if err != nil {
+					fmt.Println(errorStyle.Render("✗ " + err.Error()))
+					os.Exit(1)
+				}
+				updated.Tmpfs = tmpfs
+				caps, err := editList(current.CapDrop, capDrop, removeCapDrop)
+				if err != nil {
+					fmt.Println(errorStyle.Render("✗ " + err.Error()))
+					os.Exit(1)
+				}
+				updated.CapDrop = caps
+				if authUser != "" && noAuth {
+					fmt.Println(errorStyle.Render("✗ --basic-auth and --no-basic-auth can't be used together"))
+					os.Exit(1)
+				}
+				if authUser != "" {
+					updated.BasicAuth = true
+				}
+				if noAuth {
+					updated.BasicAuth = false
+				}
+				check := appConfigFromNix(&updated, *configDir)
+				check.BasicAuthUser = authUser
+				errs := validateAppConfig(check)
+				if updated.Internal && updated.BasicAuth && authUser == "" {
+					errs = append(errs, invalid("internal", "", "can't be combined with basic auth; pass --no-basic-auth too"))
+				}
+				exitOnValidationErrors(errs)
+			}
+
+			if pin && unpin {
+				fmt.Println(errorStyle.Render("✗ --pin and --unpin can't be used together"))
+				os.Exit(1)
+			}
+			if pin {
+				updated.Image = pinImage(updated.Image, mustResolveDigest(updated.Image))
+			}
+			if unpin {
+				updated.Image, _ = splitImageDigest(updated.Image)
+			}
+
+			mustHaveNoRouteConflicts(*configDir, &updated)
+
+			// basic auth changes touch the htpasswd secret as well as the app file
+			var secrets func(tx *Transaction)
+			switch {
+			case authUser != "" && !dryRun:
+				htpasswd := mustPromptBasicAuth(authUser)
+				secrets = func(tx *Transaction) {
+					addBasicAuthSecret(tx, *configDir, appName, htpasswd)
+					fmt.Println(successStyle.Render("✓ Basic auth set for " + authUser))
+				}
+			case current.BasicAuth && !updated.BasicAuth:
+				secrets = func(tx *Transaction) {
+					removeBasicAuthSecret(tx, *configDir, appName)
+				}
+			}
+			writeEditedConfig(*configDir, filePath, current, &updated, dryRun, secrets)
+		},
+	}
+
+	cmd.Flags().StringVar(&image, "image", "", "new docker image url")
+	cmd.Flags().StringVar(&domain, "domain", "", "new main domain")
+	cmd.Flags().StringVar(&subdomain, "subdomain", "", "new subdomain (pass \"\" to remove it)")
+	cmd.Flags().IntVar(&port, "port", 0, "new port the container exposes")
+	cmd.Flags().StringVar(&network, "network", "", "new traefik docker network")
+	cmd.Flags().StringArrayVar(&addMounts, "add-mount", []string{}, "add a mount (e.g., /host:/container[:ro|rw])")
+	cmd.Flags().StringArrayVar(&removeMounts, "remove-mount", []string{}, "remove a mount, by full spec or container path")
+	cmd.Flags().StringArrayVar(&env, "env", []string{}, "set a plain-text environment variable (KEY=VALUE, repeatable)")
+	cmd.Flags().StringVar(&envFrom, "env-from-file", "", "set plain-text environment variables from a KEY=VALUE file")
+	cmd.Flags().StringArrayVar(&unsetEnv, "unset-env", []string{}, "remove a plain-text environment variable")
+	cmd.Flags().StringArrayVar(&addHosts, "add-host", []string{}, "serve an extra hostname (repeatable)")
+	cmd.Flags().StringArrayVar(&removeHosts, "remove-host", []string{}, "stop serving an extra hostname")
+	cmd.Flags().BoolVar(&noWWW, "no-www", false, "drop the www. aliases (--no-www=false adds them back)")
+	cmd.Flags().StringVar(&prefix, "path-prefix", "", "only route requests under this path (pass \"\" to route the whole host)")
+	cmd.Flags().BoolVar(&strip, "strip-prefix", false, "strip the path prefix before forwarding to the container")
+	cmd.Flags().IntVar(&priority, "priority", 0, "router priority (0 leaves it to traefik)")
+	cmd.Flags().StringVar(&authUser, "basic-auth", "", "set HTTP basic auth for this user (prompts for the password)")
+	cmd.Flags().BoolVar(&noAuth, "no-basic-auth", false, "remove HTTP basic auth and its secret")
+	cmd.Flags().StringArrayVar(&allowIPs, "allow-ip", []string{}, "allow requests from this IP or CIDR range (repeatable)")
+	cmd.Flags().StringArrayVar(&removeIPs, "remove-allow-ip", []string{}, "remove an allowed IP or CIDR range")
+	cmd.Flags().StringVar(&rateLimit, "rate-limit", "", "rate limit requests per client (e.g., 100/s; pass \"\" to remove)")
+	cmd.Flags().BoolVar(&headers, "security-headers", false, "add security headers (--security-headers=false removes them)")
+	cmd.Flags().BoolVar(&compress, "compress", false, "compress responses (--compress=false turns it off)")
+	cmd.Flags().StringVar(&healthPath, "health-path", "", "path traefik polls for health (pass \"\" to remove the check)")
+	cmd.Flags().StringVar(&healthCmd, "health-cmd", "", "command docker runs to check health (pass \"\" to remove the check)")
+	cmd.Flags().StringVar(&healthInterval, "health-interval", "", "time between health checks")
+	cmd.Flags().StringVar(&healthTimeout, "health-timeout", "", "time before a health check counts as failed")
+	cmd.Flags().IntVar(&healthRetries, "health-retries", 0, "consecutive failures before docker marks the container unhealthy")
+	cmd.Flags().StringVar(&memory, "memory", "", "memory limit (e.g., 512m; pass \"\" to remove)")
+	cmd.Flags().StringVar(&cpus, "cpus", "", "CPU limit (e.g., 0.5; pass \"\" to remove)")
+	cmd.Flags().StringVar(&restart, "restart", "", "restart policy: "+strings.Join(restartPolicies, ", ")+" (pass \"\" for the default)")
+	cmd.Flags().StringVar(&user, "user", "", "user to run the container as (pass \"\" for the image default)")
+	cmd.Flags().BoolVar(&readOnly, "read-only", false, "mount the root filesystem read-only (--read-only=false turns it off)")
+	cmd.Flags().StringArrayVar(&addTmpfs, "tmpfs", []string{}, "add a tmpfs mount (e.g., /tmp)")
+	cmd.Flags().StringArrayVar(&removeTmpfs, "remove-tmpfs", []string{}, "remove a tmpfs mount")
+	cmd.Flags().StringArrayVar(&capDrop, "cap-drop", []string{}, "drop a Linux capability (e.g., ALL)")
+	cmd.Flags().StringArrayVar(&removeCapDrop, "remove-cap-drop", []string{}, "stop dropping a Linux capability")
+	cmd.Flags().BoolVar(&internal, "internal", false, "stop exposing the app through traefik (--internal=false exposes it again)")
+	cmd.Flags().StringArrayVar(&joinNetworks, "join-network", []string{}, "join a named docker network shared with other apps")
+	cmd.Flags().StringArrayVar(&leaveNetworks, "leave-network", []string{}, "leave a named docker network")
+	cmd.Flags().BoolVar(&pin, "pin", false, "pin the image to the digest its tag points to now")
+	cmd.Flags().BoolVar(&unpin, "unpin", false, "go back to pulling the image tag on every start")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the diff but don't write it to disk")
+
+	return cmd
+}
+
+// appConfigFromNix converts a parsed app back into the shape the TUI and
+// validation work with.
+func appConfigFromNix(c *NixAppConfig, configDir string) AppConfig {
+	return AppConfig{
+		Name:        c.Name,
+		Image:       c.Image,
+		Domain:      c.Domain,
+		Subdomain:   c.Subdomain,
+		Aliases:     c.Aliases,
+		NoWWW:       c.NoWWW,
+		PathPrefix:  c.PathPrefix,
+		StripPrefix: c.StripPrefix,
+		Priority:    c.Priority,
+		Port:        c.ContainerPort,
+
+		AllowIPs:        c.AllowIPs,
+		RateLimit:       c.RateLimit,
+		SecurityHeaders: c.SecurityHeaders,
+		Compress:        c.Compress,
+		HealthPath:      c.HealthPath,
+		HealthCmd:       c.HealthCmd,
+		HealthInterval:  c.HealthInterval,
+		HealthTimeout:   c.HealthTimeout,
+		HealthRetries:   c.HealthRetries,
+		Memory:          c.Memory,
+		CPUs:            c.CPUs,
+		Restart:         c.Restart,
+		User:            c.User,
+		ReadOnly:        c.ReadOnly,
+		Tmpfs:           c.Tmpfs,
+		CapDrop:         c.CapDrop,
+		Internal:        c.Internal,
+		JoinNetworks:    c.JoinNetworks,
+		ConfigDir:       configDir,
+		Network:         c.Network,
+		Mounts:          c.Mounts,
+		Env:             c.Environment,
+	}
+}
+
+// editMounts removes and then adds mounts. A removal matches either the full
+// mount spec or its container path.
+func editMounts(mounts, add, remove []string) ([]string, error) {
+	out := append([]string{}, mounts...)
+
+	for _, r := range remove {
+		idx := -1
+		for i, m := range out {
+			parts := strings.Split(m, ":")
+			if m == r || (len(parts) >= 2 && parts[1] == r) {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			return nil, fmt.Errorf("no mount matching %q", r)
+		}
+		out = append(out[:idx], out[idx+1:]...)
+	}
+
+	for _, a := range add {
+		for _, m := range out {
+			if m == a {
+				return nil, fmt.Errorf("mount %q already exists", a)
+			}
+		}
+		out = append(out, a)
+	}
+
+	return out, nil
+}
+
+// editList removes and then adds values compared case-insensitively, such as
+// extra hostnames, networks, allowed IP ranges, tmpfs mounts and capabilities.
+// Values already in the list aren't added again.
+func editList(list, add, remove []string) ([]string, error) {
+	out := append([]string{}, list...)
+
+	for _, r := range remove {
+		idx := indexFold(out, r)
+		if idx == -1 {
+			return nil, fmt.Errorf("nothing matching %q to remove", r)
+		}
+		out = append(out[:idx], out[idx+1:]...)
+	}
+
+	for _, a := range add {
+		if indexFold(out, a) == -1 {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
+func indexFold(list []string, s string) int {
+	for i, v := range list {
+		if strings.EqualFold(v, s) {
+			return i
+		}
+	}
+	return -1
+}
+
+// editEnv unsets keys and then applies the env file and --env assignments.
+func editEnv(current map[string]string, file string, set, unset []string) (map[string]string, error) {
+	base := map[string]string{}
+	for k, v := range current {
+		base[k] = v
+	}
+	for _, key := range unset {
+		if _, ok := base[key]; !ok {
+			return nil, fmt.Errorf("no environment variable named %q", key)
+		}
+		delete(base, key)
+	}
+	return buildEnv(base, file, set)
+}
+
+// writeEditedConfig shows the diff and writes the new app file. secrets, if
+// set, runs in the same transaction after the write for changes to the app's
+// secret files.
+func writeEditedConfig(configDir, filePath string, current, updated *NixAppConfig, dryRun bool, secrets func(tx *Transaction)) {
+	// the host port always comes from the registry, like init. Internal apps
+	// give theirs back, and apps that stop being internal get a new one
+	registry, err := loadPortRegistry(configDir)
+	if err != nil {
+		fmt.Println(errorStyle.Render("✗ Failed to load port registry: " + err.Error()))
+		os.Exit(1)
+	}
+	_, allocated := registry.Allocations[updated.Name]
+	registryChanged := false
+	switch {
+	case updated.Internal && allocated:
+		delete(registry.Allocations, updated.Name)
+		registryChanged = true
+	case !updated.Internal && !allocated && current.Internal:
+		if _, err := allocatePort(registry, updated.Name); err != nil {
+			fmt.Println(errorStyle.Render("✗ Failed to allocate port: " + err.Error()))
+			os.Exit(1)
+		}
+		registryChanged = true
+	}
+	if port, ok := registry.Allocations[updated.Name]; ok || updated.Internal {
+		updated.HostPort = port
+	}
+
+	oldContent, err := os.ReadFile(filePath)
+	if err != nil {
+		fmt.Println(errorStyle.Render("✗ Failed to read app config: " + err.Error()))
+		os.Exit(1)
+	}
+	newContent := updated.Generate()
+
+	// compare against a regenerated copy so formatting-only drift isn't shown
+	// as a change the user asked for
+	unchanged := current.Generate() == newContent
+	if unchanged && secrets == nil {
+		fmt.Println(mutedStyle.Render("ℹ️ No changes to " + filePath))
+		return
+	}
+
+	if !unchanged {
+		diff := unifiedDiff("a/"+filepath.Base(filePath), "b/"+filepath.Base(filePath), string(oldContent), newContent)
+		printDiff(diff)
+	}
+
+	if dryRun {
+		return
+	}
+
+	tx := newTransaction()
+	if !unchanged {
+		if err := tx.Step("Writing "+filePath, func() error {
+			return tx.WriteFile(filePath, []byte(newContent), 0o644)
+		}); err != nil {
+			abortTransaction(tx, err)
+		}
+		fmt.Println(successStyle.Render("✓ Configuration written to " + filePath))
+	}
+	if registryChanged {
+		if err := tx.Step("Saving port registry", func() error {
+			if err := tx.Track(filepath.Join(configDir, "ports.json")); err != nil {
+				return err
+			}
+			return savePortRegistry(registry, configDir)
+		}); err != nil {
+			abortTransaction(tx, err)
+		}
+	}
+	if secrets != nil {
+		secrets(tx)
+	}
+	tx.Commit()
+}
+
+// mustLoadAppForEdit parses an app file that is about to be rewritten,
+// refusing files that were modified by hand.
+func mustLoadAppForEdit(configDir, appName string) *NixAppConfig {
+	filePath := filepath.Join(configDir, "apps", appName+".nix")
+	config, err := parseAppFile(filePath)
+	if err != nil {
+		var perr *AppParseError
+		if errors.As(err, &perr) {
+			fmt.Println(errorStyle.Render("✗ Refusing to edit a hand-modified app file"))
+			fmt.Println(mutedStyle.Render(perr.Error()))
+		} else {
+			fmt.Println(errorStyle.Render("✗ Failed to read app config: " + err.Error()))
+		}
+		os.Exit(1)
+	}
+	return config
+}
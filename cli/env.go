@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// parseEnvAssignment splits a KEY=VALUE pair as passed to --env.
+func parseEnvAssignment(s string) (string, string, error) {
+	key, value, ok := strings.Cut(s, "=")
+	key = strings.TrimSpace(key)
+	if !ok {
+		return "", "", invalid("env", s, "must be KEY=VALUE")
+	}
+	if err := validateEnvKey(key); err != nil {
+		return "", "", err
+	}
+	return key, value, nil
+}
+
+// parseEnvFile reads a dotenv-style file: KEY=VALUE per line, blank lines
+// and # comments ignored, an optional leading `export`, and values optionally
+// wrapped in single or double quotes.
+func parseEnvFile(content string) (map[string]string, error) {
+	env := map[string]string{}
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, err := parseEnvAssignment(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env[key] = value
+	}
+	return env, nil
+}
+
+func readEnvFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	env, err := parseEnvFile(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return env, nil
+}
+
+// buildEnv merges an optional env file with --env assignments, which win.
+func buildEnv(base map[string]string, file string, assignments []string) (map[string]string, error) {
+	env := map[string]string{}
+	for k, v := range base {
+		env[k] = v
+	}
+
+	if file != "" {
+		fromFile, err := readEnvFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fromFile {
+			env[k] = v
+		}
+	}
+
+	for _, a := range assignments {
+		key, value, err := parseEnvAssignment(a)
+		if err != nil {
+			return nil, err
+		}
+		env[key] = value
+	}
+
+	if len(env) == 0 {
+		return nil, nil
+	}
+	return env, nil
+}
+
+// setEnvLine sets key in dotenv content, replacing an existing assignment in
+// place or appending one, and leaves every other line untouched.
+func setEnvLine(content []byte, key, value string) []byte {
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(content) == 0 {
+		lines = nil
+	}
+	assignment := key + "=" + value
+	replaced := false
+	for i, line := range lines {
+		k, _, ok := strings.Cut(strings.TrimPrefix(strings.TrimSpace(line), "export "), "=")
+		if ok && strings.TrimSpace(k) == key {
+			lines[i] = assignment
+			replaced = true
+		}
+	}
+	if !replaced {
+		lines = append(lines, assignment)
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// removeEnvLine drops every assignment of key from dotenv content and reports
+// whether there was one.
+func removeEnvLine(content []byte, key string) ([]byte, bool) {
+	var kept []string
+	removed := false
+	for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+		k, _, ok := strings.Cut(strings.TrimPrefix(strings.TrimSpace(line), "export "), "=")
+		if ok && strings.TrimSpace(k) == key {
+			removed = true
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if len(kept) == 0 || (len(kept) == 1 && kept[0] == "") {
+		return nil, removed
+	}
+	return []byte(strings.Join(kept, "\n") + "\n"), removed
+}
+
+// sortedEnvKeys returns the keys of env in a stable order for rendering.
+func sortedEnvKeys(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// secretKeySuffixes are the endings that make a plain-text variable look
+// like it should have gone into the agenix secret instead.
+var secretKeySuffixes = []string{"_TOKEN", "_PASSWORD", "_SECRET", "_API_KEY", "_PRIVATE_KEY"}
+
+func looksLikeSecret(key string) bool {
+	upper := strings.ToUpper(key)
+	for _, suffix := range secretKeySuffixes {
+		if upper == strings.TrimPrefix(suffix, "_") || strings.HasSuffix(upper, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// EnvSchema declares the variables an app reads from its secret. Required
+// ones are checked before a secret is encrypted and by `rollout check`;
+// optional ones only mark the rest of the secret as expected.
+type EnvSchema struct {
+	Required []string `json:"required,omitempty"`
+	Optional []string `json:"optional,omitempty"`
+}
+
+// EnvSchemaRegistry is env-schema.json in the config dir, keyed by app.
+type EnvSchemaRegistry struct {
+	Apps map[string]EnvSchema `json:"apps"`
+}
+
+func loadEnvSchemas(configDir string) (*EnvSchemaRegistry, error) {
+	registry := &EnvSchemaRegistry{Apps: map[string]EnvSchema{}}
+
+	data, err := os.ReadFile(filepath.Join(configDir, "env-schema.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return registry, nil
+		}
+		return nil, fmt.Errorf("failed to read env schemas: %w", err)
+	}
+	if err := json.Unmarshal(data, registry); err != nil {
+		return nil, fmt.Errorf("failed to parse env schemas: %w", err)
+	}
+	if registry.Apps == nil {
+		registry.Apps = map[string]EnvSchema{}
+	}
+	return registry, nil
+}
+
+func saveEnvSchemas(registry *EnvSchemaRegistry, configDir string) error {
+	data, err := json.MarshalIndent(registry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal env schemas: %w", err)
+	}
+	if err := atomicWriteFile(filepath.Join(configDir, "env-schema.json"), data, 0o644); err != nil {
+		return fmt.Errorf("failed to write env schemas: %w", err)
+	}
+	return nil
+}
+
+// appEnvSchema returns an app's declared schema, empty if it has none.
+func appEnvSchema(configDir, appName string) (EnvSchema, error) {
+	registry, err := loadEnvSchemas(configDir)
+	if err != nil {
+		return EnvSchema{}, err
+	}
+	return registry.Apps[appName], nil
+}
+
+func mustLoadAppEnvSchema(configDir, appName string) EnvSchema {
+	schema, err := appEnvSchema(configDir, appName)
+	if err != nil {
+		fmt.Println(errorStyle.Render("✗ " + err.Error()))
+		os.Exit(1)
+	}
+	return schema
+}
+
+// missingEnv returns the required keys env doesn't set, leaving out the ones
+// in provided.
+func (s EnvSchema) missingEnv(env map[string]string, provided ...string) []string {
+	var missing []string
+	for _, key := range s.Required {
+		if _, ok := env[key]; !ok && !containsString(provided, key) {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// undeclaredEnv returns the keys env sets that the schema doesn't list,
+// leaving out the ones in provided.
+func (s EnvSchema) undeclaredEnv(env map[string]string, provided ...string) []string {
+	if len(s.Required)+len(s.Optional) == 0 {
+		return nil
+	}
+	var undeclared []string
+	for _, key := range sortedEnvKeys(env) {
+		if !containsString(s.Required, key) && !containsString(s.Optional, key) && !containsString(provided, key) {
+			undeclared = append(undeclared, key)
+		}
+	}
+	return undeclared
+}
+
+// checkRequiredEnv fails if a secret's plain text lacks a required key.
+func checkRequiredEnv(schema EnvSchema, content []byte, provided ...string) error {
+	env, err := parseEnvFile(string(content))
+	if err != nil {
+		return err
+	}
+	if missing := schema.missingEnv(env, provided...); len(missing) > 0 {
+		return fmt.Errorf("missing required %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// companionEnvKeys are the variables an app's companion services add to its
+// secret.
+func companionEnvKeys(services []string) []string {
+	var keys []string
+	for _, s := range services {
+		keys = append(keys, companionServices[s].URLKey)
+	}
+	return keys
+}
+
+// validateEnvSchema checks the keys declared by --require-env and
+// --optional-env.
+func validateEnvSchema(required, optional []string) []error {
+	var errs []error
+	seen := map[string]bool{}
+	for _, list := range []struct {
+		field string
+		keys  []string
+	}{{"require-env", required}, {"optional-env", optional}} {
+		for _, key := range list.keys {
+			if !envKeyPattern.MatchString(key) {
+				errs = append(errs, invalid(list.field, key, envKeyReason))
+				continue
+			}
+			if seen[key] {
+				errs = append(errs, invalid(list.field, key, "is listed more than once"))
+			}
+			seen[key] = true
+		}
+	}
+	return errs
+}
+
+func newSecretsSchemaCmd(configDir *string) *cobra.Command {
+	var require, optional, forget []string
+
+	cmd := &cobra.Command{
+		Use:   "schema <app>",
+		Short: "show or change the env keys an app's secret must contain",
+		Long: "Without flags, prints the keys declared for the app. Required keys are checked\n" +
+			"whenever the secret is written, and by `rollout check`.",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			appName := args[0]
+			mustValidateAppArg(appName)
+			if len(require)+len(optional)+len(forget) == 0 {
+				schema := mustLoadAppEnvSchema(*configDir, appName)
+				if len(schema.Required)+len(schema.Optional) == 0 {
+					fmt.Println(mutedStyle.Render("ℹ️ No env keys declared for " + appName))
+					return
+				}
+				if len(schema.Required) > 0 {
+					fmt.Printf("Required: %s\n", successStyle.Render(strings.Join(schema.Required, ", ")))
+				}
+				if len(schema.Optional) > 0 {
+					fmt.Printf("Optional: %s\n", successStyle.Render(strings.Join(schema.Optional, ", ")))
+				}
+				return
+			}
+
+			if !fileExists(filepath.Join(*configDir, "apps", appName+".nix")) {
+				fmt.Println(errorStyle.Render("✗ No app named " + appName))
+				os.Exit(1)
+			}
+			exitOnValidationErrors(validateEnvSchema(require, optional))
+
+			lock := mustLockConfigDir(*configDir)
+			defer lock.Unlock()
+
+			registry, err := loadEnvSchemas(*configDir)
+			if err != nil {
+				fmt.Println(errorStyle.Render("✗ " + err.Error()))
+				os.Exit(1)
+			}
+			schema := registry.Apps[appName]
+			drop := append(append(append([]string{}, forget...), require...), optional...)
+			schema.Required = append(withoutStrings(schema.Required, drop), require...)
+			schema.Optional = append(withoutStrings(schema.Optional, drop), optional...)
+			if len(schema.Required)+len(schema.Optional) == 0 {
+				delete(registry.Apps, appName)
+			} else {
+				registry.Apps[appName] = schema
+			}
+
+			tx := newTransaction()
+			if err := tx.Step("Saving env schemas", func() error {
+				if err := tx.Track(filepath.Join(*configDir, "env-schema.json")); err != nil {
+					return err
+				}
+				return saveEnvSchemas(registry, *configDir)
+			}); err != nil {
+				abortTransaction(tx, err)
+			}
+			tx.Commit()
+			fmt.Println(successStyle.Render("✓ Updated the env schema of " + appName))
+			fmt.Println(mutedStyle.Render("Run `rollout check` to verify its secret"))
+		},
+	}
+	cmd.Flags().StringSliceVar(&require, "require-env", []string{}, "keys the secret must contain (comma-separated or repeatable)")
+	cmd.Flags().StringSliceVar(&optional, "optional-env", []string{}, "keys the secret may contain (comma-separated or repeatable)")
+	cmd.Flags().StringSliceVar(&forget, "forget-env", []string{}, "keys to stop declaring (comma-separated or repeatable)")
+
+	return cmd
+}
+
+func withoutStrings(list, drop []string) []string {
+	var out []string
+	for _, s := range list {
+		if !containsString(drop, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
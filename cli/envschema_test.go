@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEnvSchemaMissingAndUndeclared(t *testing.T) {
+	schema := EnvSchema{Required: []string{"DATABASE_URL", "SECRET_KEY"}, Optional: []string{"SENTRY_DSN"}}
+
+	tests := []struct {
+		name           string
+		schema         EnvSchema
+		env            map[string]string
+		provided       []string
+		wantMissing    []string
+		wantUndeclared []string
+	}{
+		{
+			name:   "complete",
+			schema: schema,
+			env:    map[string]string{"DATABASE_URL": "postgres://", "SECRET_KEY": "x", "SENTRY_DSN": "https://"},
+		},
+		{
+			name:           "missing and extra keys",
+			schema:         schema,
+			env:            map[string]string{"SECRET_KEY": "x", "DEBUG": "1", "API_URL": "https://"},
+			wantMissing:    []string{"DATABASE_URL"},
+			wantUndeclared: []string{"API_URL", "DEBUG"},
+		},
+		{
+			name:     "provided by a companion service",
+			schema:   schema,
+			env:      map[string]string{"SECRET_KEY": "x", "REDIS_URL": "redis://"},
+			provided: companionEnvKeys([]string{"postgres", "redis"}),
+		},
+		{
+			name:   "empty value still counts as set",
+			schema: schema,
+			env:    map[string]string{"DATABASE_URL": "", "SECRET_KEY": ""},
+		},
+		{
+			name:   "no schema declares nothing undeclared",
+			schema: EnvSchema{},
+			env:    map[string]string{"ANYTHING": "x"},
+		},
+		{
+			name:        "nothing set",
+			schema:      schema,
+			wantMissing: []string{"DATABASE_URL", "SECRET_KEY"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.schema.missingEnv(tt.env, tt.provided...); !slices.Equal(got, tt.wantMissing) {
+				t.Errorf("missingEnv = %q, want %q", got, tt.wantMissing)
+			}
+			if got := tt.schema.undeclaredEnv(tt.env, tt.provided...); !slices.Equal(got, tt.wantUndeclared) {
+				t.Errorf("undeclaredEnv = %q, want %q", got, tt.wantUndeclared)
+			}
+		})
+	}
+}
+
+func TestCheckRequiredEnv(t *testing.T) {
+	schema := EnvSchema{Required: []string{"DATABASE_URL", "SECRET_KEY"}}
+
+	tests := []struct {
+		name     string
+		content  string
+		provided []string
+		want     string // error substring, empty for none
+	}{
+		{"all set", "DATABASE_URL=postgres://db\nexport SECRET_KEY='x'\n", nil, ""},
+		{"comments and blanks", "# app secret\n\nDATABASE_URL=x\nSECRET_KEY=y\n", nil, ""},
+		{"one missing", "SECRET_KEY=x\n", nil, "missing required DATABASE_URL"},
+		{"both missing", "", nil, "missing required DATABASE_URL, SECRET_KEY"},
+		{"provided by postgres", "SECRET_KEY=x\n", companionEnvKeys([]string{"postgres"}), ""},
+		{"not an env file", "SECRET_KEY\n", nil, "line 1"},
+	}
+	for _, tt := range tests {
+		err := checkRequiredEnv(schema, []byte(tt.content), tt.provided...)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: error = %v, want nil", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: error = %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestValidateEnvSchema(t *testing.T) {
+	tests := []struct {
+		name     string
+		required []string
+		optional []string
+		want     []string // rejected field:value
+	}{
+		{"valid", []string{"DATABASE_URL"}, []string{"SENTRY_DSN"}, nil},
+		{"bad keys", []string{"2FA"}, []string{"WITH-DASH"}, []string{"require-env:2FA", "optional-env:WITH-DASH"}},
+		{"repeated in one list", []string{"A", "A"}, nil, []string{"require-env:A"}},
+		{"in both lists", []string{"A"}, []string{"A"}, []string{"optional-env:A"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, err := range validateEnvSchema(tt.required, tt.optional) {
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("%s: error %v is not a *ValidationError", tt.name, err)
+			}
+			got = append(got, verr.Field+":"+verr.Value)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: rejected = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEnvSchemaRoundTrip(t *testing.T) {
+	configDir := testConfigDir(t)
+
+	schema, err := appEnvSchema(configDir, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schema.Required)+len(schema.Optional) != 0 {
+		t.Fatalf("schema without env-schema.json = %+v, want empty", schema)
+	}
+
+	want := EnvSchema{Required: []string{"DATABASE_URL"}, Optional: []string{"SENTRY_DSN"}}
+	if err := saveEnvSchemas(&EnvSchemaRegistry{Apps: map[string]EnvSchema{"web": want}}, configDir); err != nil {
+		t.Fatal(err)
+	}
+	got, err := appEnvSchema(configDir, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got.Required, want.Required) || !slices.Equal(got.Optional, want.Optional) {
+		t.Errorf("loaded schema = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Healthcheck defaults, used when only --health-path or --health-cmd is given.
+const (
+	defaultHealthInterval = "30s"
+	defaultHealthTimeout  = "5s"
+	defaultHealthRetries  = 3
+)
+
+// hasHealthcheck reports whether the app has either kind of healthcheck.
+func (c *NixAppConfig) hasHealthcheck() bool {
+	return c.HealthPath != "" || c.HealthCmd != ""
+}
+
+// healthLabels returns the traefik service healthcheck labels. traefik polls
+// HealthPath on the container port and stops routing to it while it fails.
+func (c *NixAppConfig) healthLabels() []nixLabel {
+	if c.HealthPath == "" {
+		return nil
+	}
+	prefix := "traefik.http.services." + c.Name + ".loadbalancer.healthcheck."
+	return []nixLabel{
+		{prefix + "path", nixStr(c.HealthPath)},
+		{prefix + "interval", nixStr(c.HealthInterval)},
+		{prefix + "timeout", nixStr(c.HealthTimeout)},
+	}
+}
+
+// healthOptions returns the docker run flags for HealthCmd.
+func (c *NixAppConfig) healthOptions() []string {
+	if c.HealthCmd == "" {
+		return nil
+	}
+	return []string{
+		"--health-cmd=" + c.HealthCmd,
+		"--health-interval=" + c.HealthInterval,
+		"--health-timeout=" + c.HealthTimeout,
+		"--health-retries=" + strconv.Itoa(c.HealthRetries),
+	}
+}
+
+// readHealthLabels fills the healthcheck from traefik labels; values are
+// checked afterwards against healthLabels.
+func (c *NixAppConfig) readHealthLabels(found map[string]string) {
+	prefix := "traefik.http.services." + c.Name + ".loadbalancer.healthcheck."
+	if path, ok := found[prefix+"path"]; ok {
+		c.HealthPath = path
+		c.HealthInterval = found[prefix+"interval"]
+		c.HealthTimeout = found[prefix+"timeout"]
+	}
+}
+
+// readHealthOption fills the healthcheck from one docker --health-* flag and
+// reports whether it was one.
+func (c *NixAppConfig) readHealthOption(flag, value string) bool {
+	switch flag {
+	case "--health-cmd":
+		c.HealthCmd = value
+	case "--health-interval":
+		c.HealthInterval = value
+	case "--health-timeout":
+		c.HealthTimeout = value
+	case "--health-retries":
+		c.HealthRetries, _ = strconv.Atoi(value)
+	default:
+		return false
+	}
+	return true
+}
+
+// applyHealthDefaults fills unset timings when a healthcheck is configured
+// and clears them when it isn't, so they never show up on their own.
+func (c *NixAppConfig) applyHealthDefaults() {
+	if !c.hasHealthcheck() {
+		c.HealthInterval, c.HealthTimeout, c.HealthRetries = "", "", 0
+		return
+	}
+	if c.HealthInterval == "" {
+		c.HealthInterval = defaultHealthInterval
+	}
+	if c.HealthTimeout == "" {
+		c.HealthTimeout = defaultHealthTimeout
+	}
+	if c.HealthRetries == 0 {
+		c.HealthRetries = defaultHealthRetries
+	}
+}
+
+// healthSummary describes the healthcheck for the init summary.
+func (c *NixAppConfig) healthSummary() string {
+	var parts []string
+	if c.HealthPath != "" {
+		parts = append(parts, "GET "+c.HealthPath)
+	}
+	if c.HealthCmd != "" {
+		parts = append(parts, fmt.Sprintf("`%s` (%d retries)", c.HealthCmd, c.HealthRetries))
+	}
+	return fmt.Sprintf("%s every %s, timeout %s", strings.Join(parts, " + "), c.HealthInterval, c.HealthTimeout)
+}
+
+func validateHealthPath(path string) error {
+	if path == "" {
+		return nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return invalid("health-path", path, "must start with '/'")
+	}
+	if strings.ContainsAny(path, " \t\n\"`") {
+		return invalid("health-path", path, "must not contain whitespace, quotes or backticks")
+	}
+	return nil
+}
+
+func validateHealthCmd(cmd string) error {
+	if strings.ContainsAny(cmd, "\n\r") {
+		return invalid("health-cmd", cmd, "must be a single line")
+	}
+	return nil
+}
+
+// validateDuration checks a docker/traefik duration such as 30s or 1m30s.
+func validateDuration(field, value string) error {
+	if value == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return invalid(field, value, "must be a positive duration such as 30s or 1m")
+	}
+	return nil
+}
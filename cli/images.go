@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/spf13/cobra"
+)
+
+func newImagesCmd(configDir *string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "images",
+		Short: "show and update the images apps are pinned to",
+	}
+	cmd.AddCommand(newImagesListCmd(configDir))
+	cmd.AddCommand(newImagesUpdateCmd(configDir))
+	return cmd
+}
+
+func newImagesListCmd(configDir *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "list each app's image tag and the digest it is pinned to",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			apps, err := loadAppConfigs(*configDir)
+			if err != nil {
+				fmt.Println(errorStyle.Render("✗ Failed to read apps: " + err.Error()))
+				os.Exit(1)
+			}
+			if len(apps) == 0 {
+				fmt.Println(mutedStyle.Render("ℹ️ No apps found"))
+				return
+			}
+
+			rows := make([][]string, 0, len(apps))
+			for _, app := range apps {
+				name, digest := splitImageDigest(app.Image)
+				if digest == "" {
+					digest = "not pinned"
+				}
+				rows = append(rows, []string{app.Name, name, digest})
+			}
+
+			t := table.New().
+				Border(lipgloss.RoundedBorder()).
+				BorderStyle(lipgloss.NewStyle().Foreground(borderColor)).
+				Headers("NAME", "TAG", "DIGEST").
+				Rows(rows...).
+				StyleFunc(func(row, col int) lipgloss.Style {
+					if row == table.HeaderRow {
+						return headerStyle.Padding(0, 1)
+					}
+					return inputStyle.Padding(0, 1)
+				})
+			fmt.Println(t)
+		},
+	}
+}
+
+func newImagesUpdateCmd(configDir *string) *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "update [app]",
+		Short: "resolve image tags to their current digests and pin the apps to them",
+		Long: "Resolves the tag of every pinned app's image against its registry and rewrites\n" +
+			"the app file if the digest moved. Naming an app pins it even if it isn't yet.",
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if !dryRun {
+				lock := mustLockConfigDir(*configDir)
+				defer lock.Unlock()
+			}
+
+			var apps []*NixAppConfig
+			if len(args) == 1 {
+				mustValidateAppArg(args[0])
+				apps = []*NixAppConfig{mustLoadAppForEdit(*configDir, args[0])}
+			} else {
+				all, err := loadAppConfigs(*configDir)
+				if err != nil {
+					fmt.Println(errorStyle.Render("✗ Failed to read apps: " + err.Error()))
+					os.Exit(1)
+				}
+				for _, app := range all {
+					if isPinned(app.Image) {
+						apps = append(apps, app)
+					}
+				}
+				if len(apps) == 0 {
+					fmt.Println(mutedStyle.Render("ℹ️ No pinned apps; run `rollout images update <app>` to pin one"))
+					return
+				}
+			}
+
+			failed := false
+			for _, app := range apps {
+				digest, err := resolveDigest(app.Image)
+				if err != nil {
+					fmt.Println(errorStyle.Render("✗ " + app.Name + ": " + err.Error()))
+					failed = true
+					continue
+				}
+				if _, current := splitImageDigest(app.Image); current == digest {
+					fmt.Println(mutedStyle.Render("ℹ️ " + app.Name + " is up to date (" + digest + ")"))
+					continue
+				}
+
+				updated := *app
+				updated.Image = pinImage(app.Image, digest)
+				filePath := filepath.Join(*configDir, "apps", app.Name+".nix")
+				writeEditedConfig(*configDir, filePath, app, &updated, dryRun, nil)
+			}
+			if failed {
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the diffs but don't write them to disk")
+
+	return cmd
+}
+
+// loadAppConfigs parses every app file under <configDir>/apps, sorted by
+// name. Hand-modified files are reported as errors.
+func loadAppConfigs(configDir string) ([]*NixAppConfig, error) {
+	appsDir := filepath.Join(configDir, "apps")
+	entries, err := os.ReadDir(appsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var apps []*NixAppConfig
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".nix") {
+			continue
+		}
+		config, err := parseAppFile(filepath.Join(appsDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, config)
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].Name < apps[j].Name })
+	return apps, nil
+}
+
+// mustResolveDigest is resolveDigest for commands, exiting on failure.
+func mustResolveDigest(image string) string {
+	digest, err := resolveDigest(image)
+	if err != nil {
+		fmt.Println(errorStyle.Render("✗ Failed to resolve image digest: " + err.Error()))
+		os.Exit(1)
+	}
+	return digest
+}
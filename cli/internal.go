@@ -0,0 +1,83 @@
+package main
+
+import "strings"
+
+// Internal apps get no traefik labels and no localhost port binding, so they
+// can only be reached by other containers on the networks they join.
+
+// exposure describes how an app is reachable, for list and check.
+func (c *NixAppConfig) exposure() string {
+	if c.Internal {
+		return "internal"
+	}
+	return "public"
+}
+
+// joinedNetworkUnit is the systemd unit that creates a named network. Every
+// app joining the network defines the same unit, which NixOS merges; the
+// join- prefix keeps it apart from the units of private networks.
+func joinedNetworkUnit(network string) string {
+	return "docker-network-join-" + network
+}
+
+// setJoinedNetworks adds a unit creating each named network to root. Only
+// this app's container is listed; the lists merge across app files, and
+// creating a network that already exists is not an error.
+func (c *NixAppConfig) setJoinedNetworks(root *nixNode) {
+	docker := nixRef("pkgs", "docker")
+	unit := "docker-" + c.Name + ".service"
+	for i, network := range c.JoinNetworks {
+		def := nixAttrSet()
+		def.set(nixStr("Create the "+network+" docker network"), "description")
+		def.set(nixStrList(unit), "requiredBy")
+		def.set(nixStrList(unit), "before")
+		def.set(nixStr("oneshot"), "serviceConfig", "Type")
+		def.set(nixRef("true"), "serviceConfig", "RemainAfterExit")
+		def.set(nixInterp(nixStr("-"), docker, nixStr("/bin/docker network create "+network)), "serviceConfig", "ExecStart")
+		b := root.set(def, "systemd", "services", nixQuote(joinedNetworkUnit(network))).spaced()
+		if i == 0 {
+			b.withComment("Shared networks")
+		}
+	}
+}
+
+// isNetworkUnitPath reports whether a leaf of an app file belongs to a unit
+// creating a docker network, either the private one or a joined one.
+func isNetworkUnitPath(path []string) bool {
+	return len(path) >= 3 && hasPathPrefix(path, "systemd", "services") && strings.HasPrefix(path[2], "docker-network-")
+}
+
+// validateJoinNetwork checks a --join-network name against the app's own
+// networks.
+func validateJoinNetwork(c AppConfig, network string) error {
+	if err := validateNetwork(network); err != nil {
+		return invalid("join-network", network, "must only contain letters, digits, '_', '.' and '-'")
+	}
+	if !c.Internal && network == c.Network {
+		return invalid("join-network", network, "is already the traefik network")
+	}
+	if strings.HasSuffix(network, "-private") {
+		return invalid("join-network", network, "must not end in -private, which is reserved for the networks apps share with their companion services")
+	}
+	return nil
+}
+
+// validateInternal rejects routing settings on an internal app, which has no
+// router for them to apply to.
+func validateInternal(c AppConfig) []error {
+	var errs []error
+	reject := func(flag string, set bool) {
+		if set {
+			errs = append(errs, invalid(flag, "", "doesn't apply to --internal apps, which have no traefik router"))
+		}
+	}
+	reject("host", len(c.Aliases) > 0)
+	reject("path-prefix", c.PathPrefix != "")
+	reject("basic-auth", c.BasicAuthUser != "" || c.BasicAuth != "")
+	reject("allow-ip", len(c.AllowIPs) > 0)
+	reject("rate-limit", c.RateLimit != "")
+	reject("security-headers", c.SecurityHeaders)
+	reject("compress", c.Compress)
+	reject("health-path", c.HealthPath != "")
+	return errs
+}
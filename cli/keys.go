@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/spf13/cobra"
+)
+
+func newKeysCmd(configDir *string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "keys",
+		Short: "manage the public keys secrets are encrypted to",
+		Long: "Keys are the names bound in the let block of secrets.nix. Every secret lists\n" +
+			"the keys that can read it; after changing them, run `rollout keys rekey` to\n" +
+			"re-encrypt the secrets for the new set.",
+	}
+	cmd.AddCommand(newKeysListCmd())
+	cmd.AddCommand(newKeysAddCmd(configDir))
+	cmd.AddCommand(newKeysRemoveCmd(configDir))
+	cmd.AddCommand(newKeysRekeyCmd(configDir))
+	return cmd
+}
+
+func newKeysListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "list the named keys in secrets.nix",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			secrets := mustLoadSecretsNix()
+			if len(secrets.keys) == 0 {
+				fmt.Println(mutedStyle.Render("ℹ️ No keys are bound in " + secrets.path))
+				return
+			}
+
+			rows := make([][]string, 0, len(secrets.keys))
+			for _, b := range secrets.keys {
+				name := b.Path[0]
+				keyType, comment := "-", ""
+				switch b.Value.Kind {
+				case nixString:
+					fields := strings.Fields(b.Value.Str)
+					keyType = fields[0]
+					if strings.HasPrefix(keyType, "age1") {
+						keyType = "age"
+					}
+					if len(fields) > 2 {
+						comment = strings.Join(fields[2:], " ")
+					}
+				case nixList:
+					keyType = "group"
+				}
+				used := 0
+				for _, entry := range secrets.entries {
+					if refersTo(publicKeysOf(entry), name) {
+						used++
+					}
+				}
+				rows = append(rows, []string{name, keyType, comment, strconv.Itoa(used)})
+			}
+
+			t := table.New().
+				Border(lipgloss.RoundedBorder()).
+				BorderStyle(lipgloss.NewStyle().Foreground(borderColor)).
+				Headers("NAME", "TYPE", "COMMENT", "SECRETS").
+				Rows(rows...).
+				StyleFunc(func(row, col int) lipgloss.Style {
+					if row == table.HeaderRow {
+						return headerStyle.Padding(0, 1)
+					}
+					return inputStyle.Padding(0, 1)
+				})
+			fmt.Println(t)
+		},
+	}
+}
+
+func newKeysAddCmd(configDir *string) *cobra.Command {
+	var replace bool
+
+	cmd := &cobra.Command{
+		Use:   "add <name> <public-key|file.pub>",
+		Short: "add a key and give it access to every secret",
+		Long: "Binds the key to <name> in secrets.nix and adds it to the publicKeys of every\n" +
+			"secret. With --replace, an existing key is swapped for the new one in place,\n" +
+			"e.g. after rotating the server's host key.",
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := args[0]
+			if !isPlainNixIdent(name) {
+				fmt.Println(errorStyle.Render("✗ " + name + " can't be used as a Nix name"))
+				os.Exit(1)
+			}
+			key, err := readPublicKey(args[1])
+			if err != nil {
+				fmt.Println(errorStyle.Render("✗ " + err.Error()))
+				os.Exit(1)
+			}
+
+			lock := mustLockConfigDir(*configDir)
+			defer lock.Unlock()
+
+			secrets := mustLoadSecretsNix()
+			existing := secrets.key(name)
+			switch {
+			case existing != nil && !replace:
+				fmt.Println(errorStyle.Render("✗ A key named " + name + " already exists"))
+				fmt.Println(mutedStyle.Render("Use --replace to rotate it"))
+				os.Exit(1)
+			case existing == nil && replace:
+				fmt.Println(errorStyle.Render("✗ No key named " + name + " to replace"))
+				os.Exit(1)
+			case existing != nil && existing.Value.Kind != nixString:
+				fmt.Println(errorStyle.Render("✗ " + name + " is a group of keys, not a key"))
+				os.Exit(1)
+			}
+
+			if err := secrets.setKey(name, key); err != nil {
+				fmt.Println(errorStyle.Render("✗ Failed to update secrets.nix: " + err.Error()))
+				os.Exit(1)
+			}
+			if !replace {
+				for _, entry := range secrets.entries {
+					if err := secrets.addReference(entry.Path[0], name); err != nil {
+						fmt.Println(errorStyle.Render("✗ Failed to update secrets.nix: " + err.Error()))
+						os.Exit(1)
+					}
+				}
+			}
+			mustSaveSecretsNix(secrets)
+
+			if replace {
+				fmt.Println(successStyle.Render("✓ Replaced key " + name))
+			} else {
+				fmt.Println(successStyle.Render(fmt.Sprintf("✓ Added key %s to %d secrets", name, len(secrets.entries))))
+			}
+			fmt.Println(mutedStyle.Render("Run `rollout keys rekey` to re-encrypt the secrets for it"))
+		},
+	}
+	cmd.Flags().BoolVar(&replace, "replace", false, "replace the key already bound to <name>")
+
+	return cmd
+}
+
+func newKeysRemoveCmd(configDir *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove <name>",
+		Short: "remove a key from secrets.nix and from every secret",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := args[0]
+
+			lock := mustLockConfigDir(*configDir)
+			defer lock.Unlock()
+
+			secrets := mustLoadSecretsNix()
+			b := secrets.key(name)
+			if b == nil {
+				fmt.Println(errorStyle.Render("✗ No key named " + name))
+				os.Exit(1)
+			}
+			for _, other := range secrets.keys {
+				if other.Value.Kind == nixList && refersTo(other.Value, name) {
+					fmt.Println(errorStyle.Render("✗ " + name + " is part of the group " + other.Path[0]))
+					fmt.Println(mutedStyle.Render("Remove it from the group in " + secrets.path + " first"))
+					os.Exit(1)
+				}
+			}
+
+			value := ""
+			if b.Value.Kind == nixString {
+				value = b.Value.Str
+			}
+			for _, entry := range secrets.entries {
+				if err := secrets.removeReference(entry.Path[0], name, value); err != nil {
+					fmt.Println(errorStyle.Render("✗ Failed to update secrets.nix: " + err.Error()))
+					os.Exit(1)
+				}
+				// a secret nobody can read can't be rekeyed or deployed
+				if recipients, err := secrets.recipients(entry.Path[0]); err == nil && len(recipients) == 0 {
+					fmt.Println(errorStyle.Render("✗ " + name + " is the only key for " + entry.Path[0]))
+					fmt.Println(mutedStyle.Render("Add another key before removing it"))
+					os.Exit(1)
+				}
+			}
+			if err := secrets.removeKey(name); err != nil {
+				fmt.Println(errorStyle.Render("✗ Failed to update secrets.nix: " + err.Error()))
+				os.Exit(1)
+			}
+			mustSaveSecretsNix(secrets)
+
+			fmt.Println(successStyle.Render("✓ Removed key " + name))
+			fmt.Println(mutedStyle.Render("Run `rollout keys rekey` so the secrets are no longer encrypted to it"))
+		},
+	}
+}
+
+func newKeysRekeyCmd(configDir *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "rekey",
+		Short: "re-encrypt every secret to the keys secrets.nix lists for it",
+		Long: "Decrypts every secret with your identity and encrypts it again for its current\n" +
+			"recipients. Secrets your identity can't decrypt are left as they are and\n" +
+			"reported; the command then exits with status 1.",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			lock := mustLockConfigDir(*configDir)
+			defer lock.Unlock()
+
+			secrets := mustLoadSecretsNix()
+			tx := newTransaction()
+			var rekeyed int
+			var failed []string
+			for _, entry := range secrets.entries {
+				path := entry.Path[0]
+				if !fileExists(secretFilePath(path)) {
+					fmt.Println(mutedStyle.Render("ℹ️ Skipping " + path + " (not created yet)"))
+					continue
+				}
+				plain, err := decryptSecretData(path)
+				if err != nil {
+					fmt.Println(errorStyle.UnsetBold().Render("✗ " + err.Error()))
+					failed = append(failed, path)
+					continue
+				}
+				if err := tx.Step("Re-encrypting "+path, func() error {
+					if err := tx.Track(secretFilePath(path)); err != nil {
+						return err
+					}
+					return encryptSecretData(plain, path)
+				}); err != nil {
+					abortTransaction(tx, err)
+				}
+				rekeyed++
+			}
+			tx.Commit()
+
+			fmt.Println()
+			fmt.Println(successStyle.Render(fmt.Sprintf("✓ Rekeyed %d secrets", rekeyed)))
+			if len(failed) > 0 {
+				fmt.Println(errorStyle.Render(fmt.Sprintf("✗ Couldn't decrypt %d secrets with your identity:", len(failed))))
+				for _, path := range failed {
+					fmt.Println(mutedStyle.Render("  " + path))
+				}
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+// readPublicKey accepts a public key or the path of a .pub file holding one,
+// and returns it in the form secrets.nix lists it.
+func readPublicKey(arg string) (string, error) {
+	key := arg
+	if !strings.HasPrefix(arg, "age1") && !strings.HasPrefix(arg, "ssh-") {
+		content, err := os.ReadFile(arg)
+		if err != nil {
+			return "", fmt.Errorf("%s is neither a public key nor a readable file: %w", arg, err)
+		}
+		key = strings.TrimSpace(string(content))
+	}
+	if _, err := parseRecipient(key); err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+	return key, nil
+}
+
+func mustLoadSecretsNix() *secretsNix {
+	secrets, err := loadSecretsNix(secretsNixPath())
+	if err != nil {
+		fmt.Println(errorStyle.Render("✗ Failed to read secrets.nix: " + err.Error()))
+		os.Exit(1)
+	}
+	return secrets
+}
+
+func mustSaveSecretsNix(secrets *secretsNix) {
+	tx := newTransaction()
+	if err := tx.Step("Updating secrets.nix", func() error {
+		if err := tx.Track(secrets.path); err != nil {
+			return err
+		}
+		return secrets.save()
+	}); err != nil {
+		abortTransaction(tx, err)
+	}
+	tx.Commit()
+}
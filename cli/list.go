@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/spf13/cobra"
+)
+
+// AppListing is a single row of `rollout list`, joined from ports.json, the
+// app's .nix file and secrets.nix. Secrets is only set when the app reads its
+// secret and both the .age file and its secrets.nix entry exist; when the
+// three disagree, SecretsIssue says how.
+type AppListing struct {
+	Name          string   `json:"name"`
+	Image         string   `json:"image"`
+	URL           string   `json:"url"`
+	Public        bool     `json:"public"`
+	Hosts         []string `json:"hosts"`
+	ContainerPort int      `json:"container_port"`
+	HostPort      int      `json:"host_port"`
+	Network       string   `json:"network"`
+	Secrets       bool     `json:"secrets"`
+	SecretsIssue  string   `json:"secrets_issue,omitempty"`
+	Mounts        int      `json:"mounts"`
+}
+
+func newListCmd(configDir *string) *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "list every app managed in the config directory",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			apps, err := listApps(*configDir)
+			if err != nil {
+				fmt.Println(errorStyle.Render("✗ Failed to list apps: " + err.Error()))
+				os.Exit(1)
+			}
+
+			switch output {
+			case "json":
+				data, err := json.MarshalIndent(apps, "", "  ")
+				if err != nil {
+					fmt.Println(errorStyle.Render("✗ Failed to encode apps: " + err.Error()))
+					os.Exit(1)
+				}
+				fmt.Println(string(data))
+			case "table":
+				printAppTable(apps)
+			default:
+				fmt.Println(errorStyle.Render("✗ Unknown output format: " + output))
+				fmt.Println(mutedStyle.Render("Use one of: table, json"))
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "table", "output format (table or json)")
+
+	return cmd
+}
+
+// listApps joins the port registry, the app files under <configDir>/apps and
+// the secrets.nix entries into one listing per app, sorted by name.
+func listApps(configDir string) ([]AppListing, error) {
+	registry, err := loadPortRegistry(configDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load port registry: %w", err)
+	}
+
+	secrets, err := loadSecretEntries(secretsNixPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets.nix: %w", err)
+	}
+
+	apps := make(map[string]*AppListing)
+	for name, port := range registry.Allocations {
+		// only public apps are allocated a port
+		apps[name] = &AppListing{Name: name, HostPort: port, Public: true}
+	}
+
+	appsDir := filepath.Join(configDir, "apps")
+	entries, err := os.ReadDir(appsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read apps directory: %w", err)
+	}
+	readsSecret := map[string]bool{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".nix") {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ".nix")
+		config, err := parseAppFile(filepath.Join(appsDir, entry.Name()))
+		if err != nil {
+			// list what could be read and let the user know the rest is off
+			fmt.Fprintln(os.Stderr, mutedStyle.Render("⚠️ "+err.Error()))
+		}
+		if config == nil {
+			continue
+		}
+
+		app, ok := apps[name]
+		if !ok {
+			app = &AppListing{Name: name}
+			apps[name] = app
+		}
+		fillListingFromConfig(app, config)
+		readsSecret[name] = config.HasSecrets
+	}
+
+	listing := make([]AppListing, 0, len(apps))
+	for name, app := range apps {
+		hasFile := fileExists(filepath.Join(appsDir, name+".age"))
+		app.Secrets, app.SecretsIssue = secretsState(readsSecret[name], hasFile, secrets[ageEntryPath(configDir, name)])
+		listing = append(listing, *app)
+	}
+	sort.Slice(listing, func(i, j int) bool { return listing[i].Name < listing[j].Name })
+
+	return listing, nil
+}
+
+// fillListingFromConfig copies the listed fields from a parsed app file. The
+// host port from ports.json takes precedence over the one in the file.
+func fillListingFromConfig(app *AppListing, config *NixAppConfig) {
+	app.Image = config.Image
+	app.ContainerPort = config.ContainerPort
+	if app.HostPort == 0 {
+		app.HostPort = config.HostPort
+	}
+	app.Network = config.Network
+	app.Public = !config.Internal
+	if config.Domain != "" && !config.Internal {
+		app.URL = "https://" + config.Host() + config.PathPrefix
+		app.Hosts = append([]string{config.Host()}, config.Aliases...)
+	}
+	app.Mounts = len(config.Mounts)
+}
+
+// secretsState checks that an app reading a secret has both its .age file and
+// its secrets.nix entry, and that an app that doesn't has neither.
+func secretsState(reads, hasFile, hasEntry bool) (bool, string) {
+	switch {
+	case reads && hasFile && hasEntry:
+		return true, ""
+	case !reads && !hasFile && !hasEntry:
+		return false, ""
+	case reads && !hasFile:
+		return false, "no .age file"
+	case reads:
+		return false, "no secrets.nix entry"
+	case hasFile:
+		return false, "app doesn't read its .age file"
+	}
+	return false, "secrets.nix entry only"
+}
+
+// urlCell shows the primary URL and how many other hostnames the app serves.
+func urlCell(app AppListing) string {
+	if len(app.Hosts) <= 1 {
+		return app.URL
+	}
+	return fmt.Sprintf("%s (+%d)", app.URL, len(app.Hosts)-1)
+}
+
+// loadSecretEntries returns the set of paths that have a publicKeys entry in
+// secrets.nix. A missing secrets.nix yields an empty set.
+func loadSecretEntries(secretsPath string) (map[string]bool, error) {
+	entries := make(map[string]bool)
+	if !fileExists(secretsPath) {
+		return entries, nil
+	}
+
+	secrets, err := loadSecretsNix(secretsPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range secrets.entries {
+		entries[b.Path[0]] = true
+	}
+
+	return entries, nil
+}
+
+func printAppTable(apps []AppListing) {
+	if len(apps) == 0 {
+		fmt.Println(mutedStyle.Render("ℹ️ No apps found"))
+		return
+	}
+
+	rows := make([][]string, 0, len(apps))
+	for _, app := range apps {
+		secrets := "no"
+		switch {
+		case app.SecretsIssue != "":
+			secrets = "mismatch: " + app.SecretsIssue
+		case app.Secrets:
+			secrets = "yes"
+		}
+		exposure, url := "internal", "-"
+		if app.Public {
+			exposure, url = "public", urlCell(app)
+		}
+		rows = append(rows, []string{
+			app.Name,
+			app.Image,
+			exposure,
+			url,
+			portString(app.ContainerPort),
+			portString(app.HostPort),
+			app.Network,
+			secrets,
+			strconv.Itoa(app.Mounts),
+		})
+	}
+
+	t := table.New().
+		Border(lipgloss.RoundedBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(borderColor)).
+		Headers("NAME", "IMAGE", "EXPOSURE", "URL", "CONTAINER", "HOST", "NETWORK", "SECRETS", "MOUNTS").
+		Rows(rows...).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row == table.HeaderRow {
+				return headerStyle.Padding(0, 1)
+			}
+			return inputStyle.Padding(0, 1)
+		})
+
+	fmt.Println(t)
+}
+
+func portString(port int) string {
+	if port == 0 {
+		return "-"
+	}
+	return strconv.Itoa(port)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// lockFileName is the advisory lock taken in the config dir by every command
+// that changes it. It is left in place between runs and ignored by git.
+const lockFileName = ".rollout.lock"
+
+// ConfigLock is a held advisory lock on a config directory.
+type ConfigLock struct {
+	file *os.File
+}
+
+// lockConfigDir takes the config dir lock without blocking. If another
+// process holds it, the error names that process.
+func lockConfigDir(configDir string) (*ConfigLock, error) {
+	path := filepath.Join(configDir, lockFileName)
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := tryLockFile(f); err != nil {
+		holder, _ := os.ReadFile(path)
+		f.Close()
+		who := strings.TrimSpace(string(holder))
+		if who == "" {
+			who = "another process"
+		}
+		return nil, fmt.Errorf("%s is locked by %s; try again once it finishes", configDir, who)
+	}
+
+	// record who holds the lock so a blocked run can say so
+	hostname, _ := os.Hostname()
+	holder := fmt.Sprintf("%s@%s (pid %d, since %s)", os.Getenv("USER"), hostname, os.Getpid(), time.Now().Format(time.RFC3339))
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(holder+"\n"), 0)
+	}
+
+	return &ConfigLock{file: f}, nil
+}
+
+// Unlock releases the lock. The lock is also released if the process exits.
+func (l *ConfigLock) Unlock() {
+	l.file.Truncate(0)
+	unlockFile(l.file)
+	l.file.Close()
+}
+
+// mustLockConfigDir takes the config dir lock or exits with an error.
+func mustLockConfigDir(configDir string) *ConfigLock {
+	lock, err := lockConfigDir(configDir)
+	if err != nil {
+		fmt.Println(errorStyle.Render("✗ " + err.Error()))
+		os.Exit(1)
+	}
+	return lock
+}
+
+// atomicWriteFile writes data to a temp file next to path and renames it into
+// place, so readers never see a partial file and a crash leaves the old one.
+func atomicWriteFile(path string, data []byte, perm os.FileMode) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// Advisory locking is only implemented for unix; elsewhere the lock is a no-op.
+
+func tryLockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -68,90 +68,212 @@ type PortRegistry struct {
 }
 
 type NixAppConfig struct {
-	Name          string
-	Image         string
-	ContainerPort int
-	Domain        string
-	Subdomain     string
-	Network       string
-	HasSecrets    bool
-	HostPort      int
-	Mounts        []string
+	Name            string
+	Image           string
+	ContainerPort   int
+	Domain          string
+	Subdomain       string
+	Aliases         []string // extra hostnames served alongside Host()
+	NoWWW           bool
+	PathPrefix      string // routes only requests under this path when set
+	StripPrefix     bool
+	Priority        int
+	BasicAuth       bool // htpasswd kept in the <name>-basic-auth agenix secret
+	AllowIPs        []string
+	RateLimit       string // e.g. 100/s
+	SecurityHeaders bool
+	Compress        bool
+	HealthPath      string // polled by traefik
+	HealthCmd       string // run by docker inside the container
+	HealthInterval  string
+	HealthTimeout   string
+	HealthRetries   int
+	Memory          string
+	CPUs            string
+	Restart         string // systemd Restart= policy, empty for the oci-containers default
+	User            string
+	ReadOnly        bool
+	Tmpfs           []string
+	CapDrop         []string
+	Services        []string // companion services, see services.go
+	Internal        bool     // no traefik labels or port binding, see internal.go
+	JoinNetworks    []string // named networks shared with other apps
+	Network         string
+	HasSecrets      bool
+	HostPort        int
+	Mounts          []string
+	EntryPoints     []string
+	CertResolver    string
+	Environment     map[string]string
 }
 
-func (c *NixAppConfig) Generate() string {
-	var hostRule string
+// Host returns the primary hostname the app is served on.
+func (c *NixAppConfig) Host() string {
 	if c.Subdomain != "" {
-		subdomainHost := fmt.Sprintf("%s.%s", c.Subdomain, c.Domain)
-		hostRule = fmt.Sprintf("Host(`%s`) || Host(`www.%s`)", subdomainHost, subdomainHost)
-	} else {
-		hostRule = fmt.Sprintf("Host(`%s`) || Host(`www.%s`)", c.Domain, c.Domain)
-	}
-
-	nixTemplate := `{ config, pkgs, ... }:
-{
-  virtualisation.oci-containers.containers."%s" = rec {
-    image = "%s";
-    ports = [ "127.0.0.1:%d:%d" ];
-    networks = [ "%s" ];
-%s
-    labels = {
-      "traefik.enable" = "true";
-      "traefik.docker.network" = "%s";
-      "traefik.http.services.%s.loadbalancer.server.port" = "%d";
-
-      # domain router
-      "traefik.http.routers.%s.rule" = "%s";
-      "traefik.http.routers.%s.entrypoints" = "websecure";
-      "traefik.http.routers.%s.tls.certresolver" = "letsencrypt";
-    };%s
-  };
-
-  # Force image pull on every deployment
-  systemd.services."docker-%s".serviceConfig.ExecStartPre = [
-    "${pkgs.docker}/bin/docker pull %s"
-  ];%s
-}`
-
-	// Use the allocated host port instead of calculating it
-	hostPort := c.HostPort
-
-	var envFileAttr, ageSecretAttr string
-	if c.HasSecrets {
-		envFileAttr = fmt.Sprintf(`
-    environmentFiles = [ config.age.secrets."%s".path ];`, c.Name)
-		ageSecretAttr = fmt.Sprintf(`
-  age.secrets."%s".file = ./%s.age;`, c.Name, c.Name)
+		return fmt.Sprintf("%s.%s", c.Subdomain, c.Domain)
+	}
+	return c.Domain
+}
+
+// Hosts returns every hostname the app answers on, primary first, including
+// the www. aliases unless NoWWW is set. Internal apps answer on none.
+func (c *NixAppConfig) Hosts() []string {
+	var hosts []string
+	if c.Internal {
+		return nil
+	}
+	for _, h := range append([]string{c.Host()}, c.Aliases...) {
+		hosts = append(hosts, h)
+		if !c.NoWWW {
+			hosts = append(hosts, "www."+h)
+		}
 	}
+	return hosts
+}
 
-	// Volumes (mounts) attribute
-	var volumesAttr string
+// URLs returns the address of every host, including the path prefix.
+func (c *NixAppConfig) URLs() []string {
+	hosts := c.Hosts()
+	urls := make([]string, len(hosts))
+	for i, h := range hosts {
+		urls[i] = "https://" + h + c.PathPrefix
+	}
+	return urls
+}
+
+// hostRule builds the Host() disjunction matching every host.
+func (c *NixAppConfig) hostRule() string {
+	matchers := make([]string, 0, len(c.Aliases)+1)
+	for _, h := range c.Hosts() {
+		matchers = append(matchers, fmt.Sprintf("Host(`%s`)", h))
+	}
+	return strings.Join(matchers, " || ")
+}
+
+// routerRule is the full traefik router rule: the hosts, narrowed to the path
+// prefix if there is one.
+func (c *NixAppConfig) routerRule() string {
+	rule := c.hostRule()
+	if c.PathPrefix == "" {
+		return rule
+	}
+	if strings.Contains(rule, " || ") {
+		rule = "(" + rule + ")"
+	}
+	return fmt.Sprintf("%s && PathPrefix(`%s`)", rule, c.PathPrefix)
+}
+
+// extraOptions returns the extra `docker run` flags for the container.
+func (c *NixAppConfig) extraOptions() []string {
+	return append(c.resourceOptions(), c.healthOptions()...)
+}
+
+// defaultRouterPriority ranks prefixed routers above the host-only ones
+// sharing their hostname, longest prefix first. traefik's own default is the
+// rule length, which a long list of hosts can push past a short prefix.
+func defaultRouterPriority(prefix string) int {
+	if prefix == "" {
+		return 0
+	}
+	return 1000 + len(prefix)
+}
+
+// traefikLabels returns the labels routing the app's hosts to its container.
+func (c *NixAppConfig) traefikLabels() *nixNode {
+	labels := nixAttrSet()
+	labels.set(nixStr("true"), "traefik.enable")
+	labels.set(nixStr(c.Network), "traefik.docker.network")
+	labels.set(nixStr(strconv.Itoa(c.ContainerPort)), "traefik.http.services."+c.Name+".loadbalancer.server.port")
+	for _, l := range c.healthLabels() {
+		labels.set(l.Value, l.Key)
+	}
+	labels.set(nixStr(c.routerRule()), "traefik.http.routers."+c.Name+".rule").spaced().withComment("domain router")
+	labels.set(nixStr(strings.Join(c.EntryPoints, ",")), "traefik.http.routers."+c.Name+".entrypoints")
+	labels.set(nixStr(c.CertResolver), "traefik.http.routers."+c.Name+".tls.certresolver")
+	if c.Priority != 0 {
+		labels.set(nixStr(strconv.Itoa(c.Priority)), "traefik.http.routers."+c.Name+".priority")
+	}
+	if middlewares := c.routerMiddlewares(); len(middlewares) > 0 {
+		labels.set(nixStr(strings.Join(middlewares, ",")), "traefik.http.routers."+c.Name+".middlewares")
+	}
+	for i, l := range c.middlewareLabels() {
+		b := labels.set(l.Value, l.Key)
+		if i == 0 {
+			b.spaced().withComment("middlewares")
+		}
+	}
+	return labels
+}
+
+// Generate renders the app as a NixOS module for oci-containers with traefik
+// labels, or without any for internal apps.
+func (c *NixAppConfig) Generate() string {
+	name := nixQuote(c.Name)
+
+	container := nixAttrSet()
+	container.Rec = true
+	container.set(nixStr(c.Image), "image")
+	if !c.Internal {
+		// Use the allocated host port instead of calculating it
+		container.set(nixStrList(fmt.Sprintf("127.0.0.1:%d:%d", c.HostPort, c.ContainerPort)), "ports")
+	}
+	if networks := c.containerNetworks(); len(networks) > 0 {
+		container.set(nixStrList(networks...), "networks")
+	}
 	if len(c.Mounts) > 0 {
-		// join mounts into Nix list of strings
-		mounts := make([]string, 0, len(c.Mounts))
-		for _, m := range c.Mounts {
-			// pass-through without validation
-			mounts = append(mounts, fmt.Sprintf("\"%s\"", m))
+		container.set(nixStrList(c.Mounts...), "volumes")
+	}
+	if deps := c.dependsOn(); len(deps) > 0 {
+		container.set(nixStrList(deps...), "dependsOn")
+	}
+	if opts := c.extraOptions(); len(opts) > 0 {
+		list := nixStrList(opts...)
+		list.Multiline = true
+		container.set(list, "extraOptions")
+	}
+
+	if !c.Internal {
+		container.set(c.traefikLabels(), "labels")
+	}
+
+	if len(c.Environment) > 0 {
+		env := nixAttrSet()
+		for _, key := range sortedEnvKeys(c.Environment) {
+			env.set(nixStr(c.Environment[key]), key)
 		}
-		volumesAttr = fmt.Sprintf("    volumes = [ %s ];", strings.Join(mounts, " "))
-	}
-
-	return fmt.Sprintf(nixTemplate,
-		c.Name,
-		c.Image,
-		hostPort, c.ContainerPort,
-		c.Network,
-		volumesAttr,
-		c.Network,
-		c.Name, c.ContainerPort,
-		c.Name, hostRule,
-		c.Name,
-		c.Name,
-		envFileAttr,
-		c.Name,
-		c.Image,
-		ageSecretAttr,
-	)
+		container.set(env, "environment")
+	}
+
+	if c.HasSecrets {
+		container.set(nixListOf(nixRef("config", "age", "secrets", name, "path")), "environmentFiles")
+	}
+
+	root := nixAttrSet()
+	root.set(container, "virtualisation", "oci-containers", "containers", name)
+
+	// pinned images can't change under the tag, so there is nothing to pull
+	if !isPinned(c.Image) {
+		pull := nixListOf(nixInterp(nixRef("pkgs", "docker"), nixStr("/bin/docker pull "+c.Image)))
+		pull.Multiline = true
+		root.set(pull, "systemd", "services", nixQuote("docker-"+c.Name), "serviceConfig", "ExecStartPre").
+			spaced().withComment("Force image pull on every deployment")
+	}
+	if c.Restart != "" {
+		root.set(nixCall([]string{"pkgs", "lib", "mkForce"}, nixStr(c.Restart)), "systemd", "services", nixQuote("docker-"+c.Name), "serviceConfig", "Restart")
+	}
+
+	if c.HasSecrets {
+		root.set(nixPathLit("./"+c.Name+".age"), "age", "secrets", name, "file")
+	}
+	if c.BasicAuth {
+		secret := basicAuthSecretName(c.Name)
+		root.set(nixPathLit("./"+secret+".age"), "age", "secrets", nixQuote(secret), "file")
+		root.set(nixStr("traefik"), "age", "secrets", nixQuote(secret), "owner")
+	}
+	c.setCompanions(root)
+	c.setJoinedNetworks(root)
+
+	return emitNixFile([]string{"config", "pkgs", "..."}, root)
 }
 
 type AppConfig struct {
@@ -166,11 +288,47 @@ type AppConfig struct {
 	EnvFile   string
 	EditEnv   bool
 	Mounts    []string
+	Env       map[string]string
+
+	Aliases     []string
+	NoWWW       bool
+	PathPrefix  string
+	StripPrefix bool
+	Priority    int
+
+	BasicAuthUser   string
+	BasicAuth       string // htpasswd line, set once the password is read
+	AllowIPs        []string
+	RateLimit       string
+	SecurityHeaders bool
+	Compress        bool
+
+	HealthPath     string
+	HealthCmd      string
+	HealthInterval string
+	HealthTimeout  string
+	HealthRetries  int
+
+	Memory   string
+	CPUs     string
+	Restart  string
+	User     string
+	ReadOnly bool
+	Tmpfs    []string
+	CapDrop  []string
+
+	Services []string
+
+	Internal     bool
+	JoinNetworks []string
+
+	RequireEnv  []string
+	OptionalEnv []string
 }
 
 // AppConfig holds the configuration fields for an app
 
-func printGitHubAction(branch string) {
+func printGitHubAction(branch, dispatchRepo string) {
 	yaml := `name: Build and Push to GitHub Container Registry
 
 on:
@@ -232,11 +390,11 @@ jobs:
             -H "Accept: application/vnd.github+json" \
             -H "Authorization: Bearer $DEPLOY_PAT" \
             -H "X-GitHub-Api-Version: 2022-11-28" \
-            https://api.github.com/repos/Kabilan108/rollouts/dispatches \
+            https://api.github.com/repos/%s/dispatches \
             -d '{"event_type":"deploy"}'`
 
 	// Print raw YAML to stdout
-	fmt.Printf(yaml, branch)
+	fmt.Printf(yaml, branch, dispatchRepo)
 
 	// Print styled messages to stderr
 	fmt.Fprintln(os.Stderr, headerStyle.Render("🚀 GitHub Actions Workflow"))
@@ -248,8 +406,9 @@ jobs:
 	fmt.Fprintln(os.Stderr, "• Push to trigger the workflow")
 }
 
-const (
-	// Port allocation range: 10000-19999 (10,000 ports available)
+// Port allocation range, 10000-19999 unless port_range is set in
+// .rollout.yaml
+var (
 	PortRangeStart = 10000
 	PortRangeEnd   = 19999
 )
@@ -289,7 +448,7 @@ func savePortRegistry(registry *PortRegistry, configDir string) error {
 		return fmt.Errorf("failed to marshal port registry: %w", err)
 	}
 
-	if err := os.WriteFile(registryPath, data, 0o644); err != nil {
+	if err := atomicWriteFile(registryPath, data, 0o644); err != nil {
 		return fmt.Errorf("failed to write port registry: %w", err)
 	}
 
@@ -306,8 +465,6 @@ func initializeRegistryFromExistingApps(registry *PortRegistry, configDir string
 		return fmt.Errorf("failed to read apps directory: %w", err)
 	}
 
-	portPattern := regexp.MustCompile(`ports = \[ "127\.0\.0\.1:(\d+):\d+" \];`)
-
 	for _, entry := range entries {
 		if !strings.HasSuffix(entry.Name(), ".nix") {
 			continue
@@ -316,62 +473,106 @@ func initializeRegistryFromExistingApps(registry *PortRegistry, configDir string
 		appName := strings.TrimSuffix(entry.Name(), ".nix")
 		filePath := filepath.Join(appsDir, entry.Name())
 
-		content, err := os.ReadFile(filePath)
-		if err != nil {
-			continue // Skip files we can't read
+		// hand-edited files still count as long as the port binding was read;
+		// files the parser rejects outright are searched for the binding, so
+		// their port isn't handed out again
+		port := 0
+		if config, _ := parseAppFile(filePath); config != nil {
+			port = config.HostPort
+		} else if port, err = scanHostPort(filePath); err != nil {
+			return fmt.Errorf("failed to read the host port of %s: %w", filePath, err)
+		}
+		if port == 0 {
+			continue
 		}
 
-		matches := portPattern.FindSubmatch(content)
-		if len(matches) >= 2 {
-			if port, err := strconv.Atoi(string(matches[1])); err == nil {
-				registry.Allocations[appName] = port
-				if port >= registry.NextPort {
-					registry.NextPort = port + 1
-				}
-			}
+		registry.Allocations[appName] = port
+		if port >= registry.NextPort {
+			registry.NextPort = port + 1
 		}
 	}
 
 	return nil
 }
 
+var hostPortPattern = regexp.MustCompile(`"127\.0\.0\.1:(\d+):\d+"`)
+
+// scanHostPort finds the localhost port binding in an app file without
+// parsing it, or 0 if there is none.
+func scanHostPort(path string) (int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	m := hostPortPattern.FindSubmatch(content)
+	if m == nil {
+		return 0, nil
+	}
+	return strconv.Atoi(string(m[1]))
+}
+
 func allocatePort(registry *PortRegistry, appName string) (int, error) {
 	// Check if app already has a port allocated
 	if port, exists := registry.Allocations[appName]; exists {
 		return port, nil
 	}
 
-	// Find next available port
-	for port := registry.NextPort; port <= PortRangeEnd; port++ {
-		// Check if port is already allocated
-		inUse := false
-		for _, allocatedPort := range registry.Allocations {
-			if allocatedPort == port {
-				inUse = true
-				break
-			}
-		}
+	inUse := make(map[int]bool, len(registry.Allocations))
+	for _, allocatedPort := range registry.Allocations {
+		inUse[allocatedPort] = true
+	}
 
-		if !inUse {
-			registry.Allocations[appName] = port
+	// Scan from the start of the range so ports freed by removed apps are
+	// reused before NextPort moves any further
+	for port := PortRangeStart; port <= PortRangeEnd; port++ {
+		if inUse[port] {
+			continue
+		}
+		registry.Allocations[appName] = port
+		if port >= registry.NextPort {
 			registry.NextPort = port + 1
-			return port, nil
 		}
+		return port, nil
 	}
 
 	return 0, fmt.Errorf("no available ports in range %d-%d", PortRangeStart, PortRangeEnd)
 }
 
 func main() {
-	var configDir string
-	defaultConfDir := filepath.Join(os.Getenv("HOME"), "repos", "rollouts", "servers")
+	s, err := loadSettings()
+	if err != nil {
+		fmt.Println(errorStyle.Render("✗ Failed to load settings: " + err.Error()))
+		os.Exit(1)
+	}
+	settings = s
+	PortRangeStart, PortRangeEnd = settings.PortRange.Start, settings.PortRange.End
+
+	var (
+		configDir string
+		repoRoot  string
+		identity  string
+	)
 
 	rootCmd := &cobra.Command{
 		Use:   "rollout",
 		Short: "rollout - nix config generator for oci-containers with traefik",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// --config-dir alone keeps the old layout: the repo root is its parent
+			if cmd.Flags().Changed("config-dir") && !cmd.Flags().Changed("repo-root") {
+				repoRoot = filepath.Dir(filepath.Clean(configDir))
+			}
+			if cmd.Flags().Changed("config-dir") || cmd.Flags().Changed("repo-root") {
+				settings.repoRootSet = true
+			}
+			settings.ConfigDir = configDir
+			settings.RepoRoot = repoRoot
+			settings.Identity = identity
+		},
 	}
 
-	rootCmd.PersistentFlags().StringVar(&configDir, "config-dir", defaultConfDir, "path to config directory")
+	rootCmd.PersistentFlags().StringVar(&configDir, "config-dir", settings.ConfigDir, "path to config directory")
+	rootCmd.PersistentFlags().StringVar(&repoRoot, "repo-root", settings.RepoRoot, "path to the rollouts repo (holds secrets.nix, used by deploy)")
+	rootCmd.PersistentFlags().StringVar(&identity, "identity", settings.Identity, "ssh or age private key to decrypt secrets with (defaults to ~/.ssh/id_ed25519, then ~/.ssh/id_rsa)")
 
 	var (
 		name      string
@@ -382,10 +583,31 @@ func main() {
 		network   string
 		dryRun    bool
 		branch    string
+		dispatch  string
 		envFile   string
 		edit      bool
 		messages  []string
 		mounts    []string
+		env       []string
+		envFrom   string
+		hosts     []string
+		noWWW     bool
+		prefix    string
+		strip     bool
+		priority  int
+		authUser  string
+		allowIPs  []string
+		rateLimit string
+		headers   bool
+		compress  bool
+		health    AppConfig // only the Health* fields are used
+		limits    AppConfig // only the resource fields are used
+		pin       bool
+		services  []string
+		internal  bool
+		joins     []string
+		require   []string
+		optional  []string
 	)
 
 	initCmd := &cobra.Command{
@@ -402,10 +624,19 @@ func main() {
 			changedEnv := cmd.Flags().Changed("env-file")
 			changedEdit := cmd.Flags().Changed("edit")
 			changedMount := cmd.Flags().Changed("mount")
+			changedVars := cmd.Flags().Changed("env") || cmd.Flags().Changed("env-from-file")
+			changedHosts := cmd.Flags().Changed("host") || cmd.Flags().Changed("no-www")
+			changedRoute := cmd.Flags().Changed("path-prefix") || cmd.Flags().Changed("strip-prefix") || cmd.Flags().Changed("priority")
+			changedMiddleware := false
+			for _, f := range []string{"basic-auth", "allow-ip", "rate-limit", "security-headers", "compress",
+				"health-path", "health-cmd", "health-interval", "health-timeout", "health-retries",
+				"memory", "cpus", "restart", "user", "read-only", "tmpfs", "cap-drop", "pin", "with", "internal", "join-network", "require-env", "optional-env"} {
+				changedMiddleware = changedMiddleware || cmd.Flags().Changed(f)
+			}
 			changedDry := cmd.Flags().Changed("dry-run")
 
-			anyInitFlag := changedName || changedImage || changedDomain || changedPort || changedSub || changedNet || changedEnv || changedEdit || changedMount || changedDry
-			onlyDryRun := changedDry && !(changedName || changedImage || changedDomain || changedPort || changedSub || changedNet || changedEnv || changedEdit || changedMount)
+			anyInitFlag := changedName || changedImage || changedDomain || changedPort || changedSub || changedNet || changedEnv || changedEdit || changedMount || changedVars || changedHosts || changedRoute || changedMiddleware || changedDry
+			onlyDryRun := changedDry && !(changedName || changedImage || changedDomain || changedPort || changedSub || changedNet || changedEnv || changedEdit || changedMount || changedVars || changedHosts || changedRoute || changedMiddleware)
 			noInitFlags := !anyInitFlag
 
 			usingTUI := onlyDryRun || noInitFlags
@@ -413,6 +644,7 @@ func main() {
 			if usingTUI {
 				// Interactive: collect all required fields via TUI
 				initial := AppConfig{
+					Domain:    domain,
 					ConfigDir: configDir,
 					Network:   network,
 					DryRun:    dryRun,
@@ -433,6 +665,19 @@ func main() {
 				return
 			}
 
+			// with --host and no explicit --domain, the first host is the
+			// primary one
+			aliases := hosts
+			if len(hosts) > 0 && !changedDomain {
+				var ok bool
+				if domain, subdomain, ok = splitHost(hosts[0]); !ok {
+					fmt.Println(errorStyle.Render("✗ Can't tell which part of " + hosts[0] + " is the domain"))
+					fmt.Println(mutedStyle.Render("Pass it with --domain, and the rest with --subdomain"))
+					os.Exit(1)
+				}
+				aliases = hosts[1:]
+			}
+
 			// Non-interactive: validate required flags
 			missing := []string{}
 			if name == "" {
@@ -441,10 +686,11 @@ func main() {
 			if image == "" {
 				missing = append(missing, "--image")
 			}
-			if domain == "" {
-				missing = append(missing, "--domain")
+			// internal apps have no router or port binding
+			if domain == "" && !internal {
+				missing = append(missing, "--domain (or --host)")
 			}
-			if port <= 0 || port > 65535 {
+			if (port <= 0 || port > 65535) && !internal {
 				missing = append(missing, "--port (1-65535)")
 			}
 			if len(missing) > 0 {
@@ -453,18 +699,81 @@ func main() {
 				os.Exit(1)
 			}
 
+			if !cmd.Flags().Changed("priority") && !internal {
+				priority = defaultRouterPriority(prefix)
+			}
+			if internal {
+				for _, f := range []string{"domain", "subdomain", "port", "network"} {
+					if cmd.Flags().Changed(f) {
+						fmt.Println(errorStyle.Render("✗ --" + f + " doesn't apply to --internal apps, which have no traefik router"))
+						os.Exit(1)
+					}
+				}
+				// the defaults only matter for the router, and any --host is
+				// kept for validation to reject
+				domain, subdomain, network, port = "", "", "", 0
+				aliases = hosts
+			}
+
+			vars, err := buildEnv(nil, envFrom, env)
+			if err != nil {
+				fmt.Println(errorStyle.Render("✗ " + err.Error()))
+				os.Exit(1)
+			}
+
 			c := AppConfig{
-				Name:      name,
-				Image:     image,
-				Domain:    domain,
-				Subdomain: subdomain,
-				Port:      port,
+				Name:        name,
+				Image:       image,
+				Domain:      domain,
+				Subdomain:   subdomain,
+				Aliases:     aliases,
+				NoWWW:       noWWW,
+				PathPrefix:  prefix,
+				StripPrefix: strip,
+				Priority:    priority,
+				Port:        port,
+
+				BasicAuthUser:   authUser,
+				AllowIPs:        allowIPs,
+				RateLimit:       rateLimit,
+				SecurityHeaders: headers,
+				Compress:        compress,
+
+				HealthPath:     health.HealthPath,
+				HealthCmd:      health.HealthCmd,
+				HealthInterval: health.HealthInterval,
+				HealthTimeout:  health.HealthTimeout,
+				HealthRetries:  health.HealthRetries,
+
+				Memory:   limits.Memory,
+				CPUs:     limits.CPUs,
+				Restart:  limits.Restart,
+				User:     limits.User,
+				ReadOnly: limits.ReadOnly,
+				Tmpfs:    limits.Tmpfs,
+				CapDrop:  limits.CapDrop,
+				Services: services,
+
+				Internal:     internal,
+				JoinNetworks: joins,
+
+				RequireEnv:  require,
+				OptionalEnv: optional,
+
 				ConfigDir: configDir,
 				Network:   network,
 				DryRun:    dryRun,
 				EnvFile:   envFile,
 				EditEnv:   edit,
 				Mounts:    mounts,
+				Env:       vars,
+			}
+			exitOnValidationErrors(validateAppConfig(c))
+			if pin {
+				c.Image = pinImage(c.Image, mustResolveDigest(c.Image))
+			}
+			if c.BasicAuthUser != "" && !c.DryRun {
+				c.BasicAuth = mustPromptBasicAuth(c.BasicAuthUser)
 			}
 			generateAndWriteConfig(c)
 		},
@@ -472,29 +781,64 @@ func main() {
 
 	initCmd.Flags().StringVar(&name, "name", "", "project name (e.g., kabilan108-com)")
 	initCmd.Flags().StringVar(&image, "image", "", "docker image url (e.g., ghcr.io/kabilan108/kabilan108.com:latest)")
-	initCmd.Flags().StringVar(&domain, "domain", "", "main domain (e.g., kabilan108.com)")
+	initCmd.Flags().StringSliceVar(&services, "with", []string{}, "attach companion services ("+strings.Join(companionServiceNames, ", ")+")")
+	initCmd.Flags().BoolVar(&pin, "pin", false, "pin the image to the digest its tag points to now, instead of pulling the tag on every start")
+	initCmd.Flags().BoolVar(&internal, "internal", false, "don't expose the app through traefik or bind a localhost port")
+	initCmd.Flags().StringArrayVar(&joins, "join-network", []string{}, "join a named docker network shared with other apps (repeatable)")
+	initCmd.Flags().StringSliceVar(&require, "require-env", []string{}, "keys the app's secret must contain (e.g., DATABASE_URL,SECRET_KEY)")
+	initCmd.Flags().StringSliceVar(&optional, "optional-env", []string{}, "keys the app's secret may contain besides the required ones")
+	initCmd.Flags().StringVar(&domain, "domain", settings.Domain, "main domain (e.g., kabilan108.com)")
 	initCmd.Flags().StringVar(&subdomain, "subdomain", "", "subdomain (leave blank for none)")
+	initCmd.Flags().StringArrayVar(&hosts, "host", []string{}, "extra hostname to serve (repeatable); without --domain the first is the primary")
+	initCmd.Flags().BoolVar(&noWWW, "no-www", false, "don't add www. aliases to the router rule")
+	initCmd.Flags().StringVar(&prefix, "path-prefix", "", "only route requests under this path (e.g., /api)")
+	initCmd.Flags().BoolVar(&strip, "strip-prefix", false, "strip --path-prefix before forwarding to the container")
+	initCmd.Flags().StringVar(&authUser, "basic-auth", "", "protect the app with HTTP basic auth for this user (prompts for the password)")
+	initCmd.Flags().StringArrayVar(&allowIPs, "allow-ip", []string{}, "only allow requests from this IP or CIDR range (repeatable)")
+	initCmd.Flags().StringVar(&rateLimit, "rate-limit", "", "rate limit requests per client (e.g., 100/s, 600/m)")
+	initCmd.Flags().BoolVar(&headers, "security-headers", false, "add HSTS, nosniff, frame-deny and referrer-policy headers")
+	initCmd.Flags().BoolVar(&compress, "compress", false, "compress responses")
+	initCmd.Flags().StringVar(&health.HealthPath, "health-path", "", "path traefik polls to decide whether to route to the app (e.g., /healthz)")
+	initCmd.Flags().StringVar(&health.HealthCmd, "health-cmd", "", "command docker runs inside the container to check its health")
+	initCmd.Flags().StringVar(&health.HealthInterval, "health-interval", defaultHealthInterval, "time between health checks")
+	initCmd.Flags().StringVar(&health.HealthTimeout, "health-timeout", defaultHealthTimeout, "time before a health check counts as failed")
+	initCmd.Flags().IntVar(&health.HealthRetries, "health-retries", defaultHealthRetries, "consecutive failures before docker marks the container unhealthy")
+	initCmd.Flags().StringVar(&limits.Memory, "memory", "", "memory limit (e.g., 512m)")
+	initCmd.Flags().StringVar(&limits.CPUs, "cpus", "", "CPU limit (e.g., 0.5)")
+	initCmd.Flags().StringVar(&limits.Restart, "restart", "", "restart policy: "+strings.Join(restartPolicies, ", ")+" (default always)")
+	initCmd.Flags().StringVar(&limits.User, "user", "", "user to run the container as (e.g., 1000:1000)")
+	initCmd.Flags().BoolVar(&limits.ReadOnly, "read-only", false, "mount the container's root filesystem read-only")
+	initCmd.Flags().StringArrayVar(&limits.Tmpfs, "tmpfs", []string{}, "mount a tmpfs (e.g., /tmp, repeatable)")
+	initCmd.Flags().StringArrayVar(&limits.CapDrop, "cap-drop", []string{}, "drop a Linux capability (e.g., ALL, repeatable)")
+	initCmd.Flags().IntVar(&priority, "priority", 0, "router priority (defaults to outranking host-only routers when --path-prefix is set)")
 	initCmd.Flags().IntVar(&port, "port", 80, "port the container exposes (e.g., 80)")
-	initCmd.Flags().StringVar(&network, "network", "web", "traefik docker network")
-	initCmd.Flags().StringVar(&envFile, "env-file", "", "path to environment file. will be encrypted with agenix")
+	initCmd.Flags().StringVar(&network, "network", settings.Network, "traefik docker network")
+	initCmd.Flags().StringVar(&envFile, "env-file", "", "path to environment file. will be encrypted to the keys in secrets.nix")
 	initCmd.Flags().BoolVar(&edit, "edit", false, "edit the environment file directly")
 	initCmd.Flags().BoolVar(&dryRun, "dry-run", false, "print out the generated config but don't write it to disk")
 	initCmd.Flags().StringArrayVar(&mounts, "mount", []string{}, "add a mount (e.g., /host:/container[:ro|rw] or name:/container[:ro|rw])")
+	initCmd.Flags().StringArrayVar(&env, "env", []string{}, "set a plain-text environment variable (KEY=VALUE, repeatable)")
+	initCmd.Flags().StringVar(&envFrom, "env-from-file", "", "read plain-text environment variables from a KEY=VALUE file (not encrypted)")
 
 	ghActionCmd := &cobra.Command{
 		Use:   "gh-action",
 		Short: "print GitHub Actions workflow for container deployment",
 		Run: func(cmd *cobra.Command, args []string) {
-			printGitHubAction(branch)
+			printGitHubAction(branch, dispatch)
 		},
 	}
 	ghActionCmd.Flags().StringVar(&branch, "branch", "main", "branch to deploy from")
+	ghActionCmd.Flags().StringVar(&dispatch, "dispatch-repo", settings.DispatchRepo, "owner/repo that receives the redeploy dispatch")
 
 	deployCmd := &cobra.Command{
 		Use:   "deploy",
 		Short: "commit and push changes to the rollouts repository",
 		Run: func(cmd *cobra.Command, args []string) {
-			runPushCommand(messages)
+			repoDir := settings.RepoRoot
+			if dir, ok := findGitRoot(); ok && !settings.repoRootSet {
+				repoDir = dir
+			}
+			runPushCommand(repoDir, messages)
 		},
 	}
 	deployCmd.Flags().StringArrayVarP(&messages, "message", "m", []string{}, "commit message (can be used multiple times for multi-line messages)")
@@ -502,12 +846,27 @@ func main() {
 	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(ghActionCmd)
 	rootCmd.AddCommand(deployCmd)
+	rootCmd.AddCommand(newListCmd(&configDir))
+	rootCmd.AddCommand(newRemoveCmd(&configDir))
+	rootCmd.AddCommand(newEditCmd(&configDir))
+	rootCmd.AddCommand(newCheckCmd(&configDir))
+	rootCmd.AddCommand(newPortsCmd(&configDir))
+	rootCmd.AddCommand(newImagesCmd(&configDir))
+	rootCmd.AddCommand(newAddServiceCmd(&configDir))
+	rootCmd.AddCommand(newSecretsCmd(&configDir))
+	rootCmd.AddCommand(newKeysCmd(&configDir))
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
 	}
 }
 
 func generateAndWriteConfig(app AppConfig) {
+	// Hold the config dir lock across the whole read-modify-write
+	if !app.DryRun {
+		lock := mustLockConfigDir(app.ConfigDir)
+		defer lock.Unlock()
+	}
+
 	// Load port registry
 	registry, err := loadPortRegistry(app.ConfigDir)
 	if err != nil {
@@ -515,25 +874,60 @@ func generateAndWriteConfig(app AppConfig) {
 		os.Exit(1)
 	}
 
-	// Allocate a port for this app
-	hostPort, err := allocatePort(registry, app.Name)
-	if err != nil {
-		fmt.Println(errorStyle.Render("✗ Failed to allocate port: " + err.Error()))
-		os.Exit(1)
+	// Allocate a port for this app, unless nothing is bound to it
+	hostPort := 0
+	if !app.Internal {
+		hostPort, err = allocatePort(registry, app.Name)
+		if err != nil {
+			fmt.Println(errorStyle.Render("✗ Failed to allocate port: " + err.Error()))
+			os.Exit(1)
+		}
 	}
 
 	config := NixAppConfig{
-		Name:          app.Name,
-		Image:         app.Image,
-		Domain:        app.Domain,
-		Subdomain:     app.Subdomain,
-		ContainerPort: app.Port,
-		Network:       app.Network,
-		HasSecrets:    app.EnvFile != "" || (app.EditEnv && app.EnvFile == ""),
-		HostPort:      hostPort,
-		Mounts:        app.Mounts,
+		Name:            app.Name,
+		Image:           app.Image,
+		Domain:          app.Domain,
+		Subdomain:       app.Subdomain,
+		Aliases:         app.Aliases,
+		NoWWW:           app.NoWWW,
+		PathPrefix:      app.PathPrefix,
+		StripPrefix:     app.StripPrefix,
+		Priority:        app.Priority,
+		BasicAuth:       app.BasicAuthUser != "",
+		AllowIPs:        app.AllowIPs,
+		RateLimit:       app.RateLimit,
+		SecurityHeaders: app.SecurityHeaders,
+		Compress:        app.Compress,
+		HealthPath:      app.HealthPath,
+		HealthCmd:       app.HealthCmd,
+		HealthInterval:  app.HealthInterval,
+		HealthTimeout:   app.HealthTimeout,
+		HealthRetries:   app.HealthRetries,
+		Memory:          app.Memory,
+		CPUs:            app.CPUs,
+		Restart:         app.Restart,
+		User:            app.User,
+		ReadOnly:        app.ReadOnly,
+		Tmpfs:           app.Tmpfs,
+		CapDrop:         app.CapDrop,
+		Services:        sortServices(app.Services),
+		Internal:        app.Internal,
+		JoinNetworks:    app.JoinNetworks,
+		ContainerPort:   app.Port,
+		Network:         app.Network,
+		HasSecrets:      app.EnvFile != "" || (app.EditEnv && app.EnvFile == "") || len(app.Services) > 0,
+		HostPort:        hostPort,
+		Mounts:          app.Mounts,
+		EntryPoints:     settings.EntryPoints,
+		CertResolver:    settings.CertResolver,
+		Environment:     app.Env,
 	}
 
+	config.applyHealthDefaults()
+	mustHaveNoRouteConflicts(app.ConfigDir, &config)
+	mustHaveNoCompanionConflicts(app.ConfigDir, config.Name, config.Services)
+
 	nixConfig := config.Generate()
 
 	// Dry-run: print only raw config, no extra output
@@ -546,175 +940,171 @@ func generateAndWriteConfig(app AppConfig) {
 	fmt.Println(headerStyle.Render("✨ Configuration Summary"))
 	fmt.Printf("Name: %s\n", successStyle.Render(config.Name))
 	fmt.Printf("Image: %s\n", successStyle.Render(config.Image))
-	if config.Subdomain != "" {
-		fmt.Printf("URL: %s\n", successStyle.Render(fmt.Sprintf("https://%s.%s", config.Subdomain, config.Domain)))
+	if config.Internal {
+		fmt.Printf("Exposure: %s\n", successStyle.Render("internal"))
+	} else if urls := config.URLs(); len(urls) == 1 {
+		fmt.Printf("URL: %s\n", successStyle.Render(urls[0]))
 	} else {
-		fmt.Printf("URL: %s\n", successStyle.Render(fmt.Sprintf("https://%s", config.Domain)))
+		fmt.Printf("URLs (%d):\n", len(urls))
+		for _, u := range urls {
+			fmt.Println("  - " + successStyle.Render(u))
+		}
+	}
+	if config.StripPrefix {
+		fmt.Printf("Strip Prefix: %s\n", successStyle.Render(config.PathPrefix))
+	}
+	if config.hasHealthcheck() {
+		fmt.Printf("Health Check: %s\n", successStyle.Render(config.healthSummary()))
+	}
+	if config.hasResources() {
+		fmt.Printf("Resources: %s\n", successStyle.Render(config.resourceSummary()))
+	}
+	if middlewares := config.routerMiddlewares(); len(middlewares) > 0 {
+		fmt.Printf("Middlewares: %s\n", successStyle.Render(strings.Join(middlewares, ", ")))
+	}
+	if !config.Internal {
+		fmt.Printf("Container Port: %s\n", successStyle.Render(fmt.Sprintf("%d", config.ContainerPort)))
+		fmt.Printf("Host Port: %s\n", successStyle.Render(fmt.Sprintf("%d", config.HostPort)))
+		fmt.Printf("Network: %s\n", successStyle.Render(config.Network))
+	}
+	if len(config.JoinNetworks) > 0 {
+		fmt.Printf("Joined Networks: %s\n", successStyle.Render(strings.Join(config.JoinNetworks, ", ")))
 	}
-	fmt.Printf("Container Port: %s\n", successStyle.Render(fmt.Sprintf("%d", config.ContainerPort)))
-	fmt.Printf("Host Port: %s\n", successStyle.Render(fmt.Sprintf("%d", config.HostPort)))
-	fmt.Printf("Network: %s\n", successStyle.Render(config.Network))
 	if config.HasSecrets {
 		fmt.Printf("Secrets: %s\n", successStyle.Render("Enabled"))
 	}
+	if len(app.RequireEnv) > 0 {
+		fmt.Printf("Required Env: %s\n", successStyle.Render(strings.Join(app.RequireEnv, ", ")))
+	}
+	if len(app.OptionalEnv) > 0 {
+		fmt.Printf("Optional Env: %s\n", successStyle.Render(strings.Join(app.OptionalEnv, ", ")))
+	}
+	if len(config.Services) > 0 {
+		fmt.Printf("Services: %s\n", successStyle.Render(strings.Join(config.Services, ", ")+" on "+privateNetwork(config.Name)))
+	}
 	if len(config.Mounts) > 0 {
 		fmt.Printf("Mounts (%d):\n", len(config.Mounts))
 		for _, mnt := range config.Mounts {
 			fmt.Println("  - " + successStyle.Render(mnt))
 		}
 	}
+	if len(config.Environment) > 0 {
+		fmt.Printf("Environment (%d):\n", len(config.Environment))
+		for _, key := range sortedEnvKeys(config.Environment) {
+			fmt.Println("  - " + successStyle.Render(key+"="+config.Environment[key]))
+		}
+	}
 
-	// File operations
-	filePath := filepath.Join(app.ConfigDir, "apps", fmt.Sprintf("%s.nix", config.Name))
-	err = os.WriteFile(filePath, []byte(nixConfig), 0o644)
-	if err != nil {
-		fmt.Println(errorStyle.Render("✗ Failed to write file: " + err.Error()))
-		os.Exit(1)
+	// File operations run as one transaction: if any step fails, every file
+	// touched so far is restored so the repo never has half an app in it
+	tx := newTransaction()
+	appsDir := filepath.Join(app.ConfigDir, "apps")
+
+	filePath := filepath.Join(appsDir, fmt.Sprintf("%s.nix", config.Name))
+	if err := tx.Step("Writing "+filePath, func() error {
+		return tx.WriteFile(filePath, []byte(nixConfig), 0o644)
+	}); err != nil {
+		abortTransaction(tx, err)
 	}
 	fmt.Println(successStyle.Render("✓ Configuration written to " + filePath))
 
 	// Save port registry after successful file write
-	if err := savePortRegistry(registry, app.ConfigDir); err != nil {
-		fmt.Println(errorStyle.Render("✗ Failed to save port registry: " + err.Error()))
-		os.Exit(1)
+	if !config.Internal {
+		if err := tx.Step("Saving port registry", func() error {
+			if err := tx.Track(filepath.Join(app.ConfigDir, "ports.json")); err != nil {
+				return err
+			}
+			return savePortRegistry(registry, app.ConfigDir)
+		}); err != nil {
+			abortTransaction(tx, err)
+		}
+	}
+
+	// Declare the env schema before the secret is encrypted against it
+	if len(app.RequireEnv)+len(app.OptionalEnv) > 0 {
+		if err := tx.Step("Saving env schema", func() error {
+			schemas, err := loadEnvSchemas(app.ConfigDir)
+			if err != nil {
+				return err
+			}
+			if err := tx.Track(filepath.Join(app.ConfigDir, "env-schema.json")); err != nil {
+				return err
+			}
+			schemas.Apps[config.Name] = EnvSchema{Required: app.RequireEnv, Optional: app.OptionalEnv}
+			return saveEnvSchemas(schemas, app.ConfigDir)
+		}); err != nil {
+			abortTransaction(tx, err)
+		}
 	}
 
 	// Handle secrets if any are needed
 	if config.HasSecrets {
-		secretsNixPath := filepath.Join(app.ConfigDir, "..", "secrets.nix")
-		if err = updateSecretsNix(config.Name, secretsNixPath); err != nil {
-			fmt.Println(errorStyle.Render("✗ Failed to update secrets.nix: " + err.Error()))
-			os.Exit(1)
+		secretsPath := secretsNixPath()
+		if err := tx.Step("Updating secrets.nix", func() error {
+			if err := tx.Track(secretsPath); err != nil {
+				return err
+			}
+			return updateSecretsNix(ageEntryPath(app.ConfigDir, config.Name), secretsPath)
+		}); err != nil {
+			abortTransaction(tx, err)
 		}
-		fmt.Println(successStyle.Render("✓ Updated " + secretsNixPath))
+		fmt.Println(successStyle.Render("✓ Updated " + secretsPath))
 
+		// the .age file is written outside tx.WriteFile, so snapshot it first
+		if err := tx.Track(filepath.Join(appsDir, fmt.Sprintf("%s.age", config.Name))); err != nil {
+			abortTransaction(tx, err)
+		}
 		if app.EditEnv {
-			err = openAgenixEditor(config.Name, filepath.Join(app.ConfigDir, "apps"))
-			if err != nil {
-				fmt.Println(errorStyle.Render("✗ Failed to open agenix editor: " + err.Error()))
-				os.Exit(1)
+			if err := tx.Step("Opening secret editor", func() error {
+				return openSecretEditor(config.Name, appsDir, companionEnvKeys(config.Services)...)
+			}); err != nil {
+				abortTransaction(tx, err)
 			}
 		} else if app.EnvFile != "" {
-			err = createAndEncryptSecret(app.EnvFile, config.Name, filepath.Join(app.ConfigDir, "apps"))
-			if err != nil {
-				fmt.Println(errorStyle.Render("✗ Failed to encrypt secrets: " + err.Error()))
-				os.Exit(1)
+			if err := tx.Step("Encrypting secrets", func() error {
+				return createAndEncryptSecret(app.EnvFile, config.Name, appsDir, companionEnvKeys(config.Services)...)
+			}); err != nil {
+				abortTransaction(tx, err)
 			}
 		}
 	}
 
-	fmt.Println(successStyle.Render("✨ Setup complete! Your application is ready to deploy."))
-}
-
-func updateSecretsNix(appName, secretsPath string) error {
-	content, err := os.ReadFile(secretsPath)
-	if err != nil {
-		return err
-	}
-
-	// check if .age file exists for the app
-	ageEntryPrefix := fmt.Sprintf(`"servers/apps/%s.age"`, appName)
-	if strings.Contains(string(content), ageEntryPrefix) {
-		fmt.Println(mutedStyle.Render("ℹ️ Skipping " + ageEntryPrefix + " (already exists)"))
-		return nil
-	}
-
-	// find the public keys
-	re := regexp.MustCompile(`"servers\/secrets\/system\.age"\.publicKeys = (\[[^\]]*\]);`)
-	matches := re.FindSubmatch(content)
-	if len(matches) < 2 {
-		return fmt.Errorf("could not find system public keys in %s", secretsPath)
+	if len(config.Services) > 0 {
+		addCompanionSecrets(tx, app.ConfigDir, config.Name, config.Services)
+		fmt.Println(successStyle.Render("✓ Credentials generated for " + strings.Join(config.Services, ", ")))
 	}
-	publicKeys := string(matches[1])
-
-	// prepare the new entry
-	newEntry := fmt.Sprintf(`
-  "servers/apps/%s.age".publicKeys = %s;
-`, appName, publicKeys)
 
-	// Find the last '}' in the file and insert the new entry before it.
-	lastBraceIndex := strings.LastIndex(string(content), "}")
-	if lastBraceIndex == -1 {
-		return fmt.Errorf("could not find closing brace in %s", secretsPath)
+	if app.BasicAuth != "" {
+		addBasicAuthSecret(tx, app.ConfigDir, config.Name, app.BasicAuth)
+		fmt.Println(successStyle.Render("✓ Basic auth enabled for " + app.BasicAuthUser))
 	}
 
-	newContent := string(content[:lastBraceIndex]) + newEntry + string(content[lastBraceIndex:])
-
-	return os.WriteFile(secretsPath, []byte(newContent), 0o644)
+	tx.Commit()
+	fmt.Println(successStyle.Render("✨ Setup complete! Your application is ready to deploy."))
 }
 
-// this function encrypts a given environment file to the correct location
-// using `agenix -e`.
-func createAndEncryptSecret(sourceEnvFile, appName, appsDir string) error {
-	sourceFile, err := os.Open(sourceEnvFile)
+// updateSecretsNix adds a publicKeys entry for a new secret, encrypted to the
+// same keys as the system secret.
+func updateSecretsNix(ageEntry, secretsPath string) error {
+	secrets, err := loadSecretsNix(secretsPath)
 	if err != nil {
-		return fmt.Errorf("could not open source env file %s: %w", sourceEnvFile, err)
+		return err
 	}
-	defer sourceFile.Close()
-
-	encryptedFilePath := filepath.Join("servers", "apps", fmt.Sprintf("%s.age", appName))
-
-	fmt.Println(promptStyle.Render(fmt.Sprintf("🔐 Encrypting %s to %s", sourceEnvFile, encryptedFilePath)))
-
-	// prepare the `agenix -e` command.
-	// we run it from the repository root so agenix can find secrets.nix.
-	cmd := exec.Command("agenix", "-e", encryptedFilePath)
-	cmd.Dir = filepath.Join(appsDir, "..", "..")
-	cmd.Stdin = sourceFile // pipe contents of source file to stdin.
-
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("agenix encryption command failed:\n%s", string(output))
+	if secrets.entry(ageEntry) != nil {
+		fmt.Println(mutedStyle.Render("ℹ️ Skipping " + ageEntry + " (already exists)"))
+		return nil
 	}
-
-	fmt.Println(mutedStyle.Render(string(output)))
-	fmt.Println(successStyle.Render("✓ Successfully encrypted secret to " + encryptedFilePath))
-	return nil
-}
-
-func openAgenixEditor(appName, appsDir string) error {
-	encryptedFilePath := filepath.Join("servers", "apps", fmt.Sprintf("%s.age", appName))
-
-	fmt.Println(promptStyle.Render(fmt.Sprintf("🔐 Opening agenix editor for %s", encryptedFilePath)))
-
-	cmd := exec.Command("agenix", "-e", encryptedFilePath)
-	cmd.Dir = filepath.Join(appsDir, "..", "..")
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	err := cmd.Run()
-	if err != nil {
-		return fmt.Errorf("agenix editor command failed: %w", err)
+	recipients := secrets.defaultRecipients()
+	if len(recipients) == 0 {
+		return fmt.Errorf("no keys found in %s to encrypt %s to", secretsPath, ageEntry)
 	}
-
-	fmt.Println(successStyle.Render("✓ Successfully edited secret " + encryptedFilePath))
-	return nil
-}
-
-func runPushCommand(messages []string) {
-	repoDir := filepath.Join(os.Getenv("HOME"), "repos", "rollouts")
-
-	// check if we're in the rollouts directory structure
-	if wd, err := os.Getwd(); err == nil {
-		if strings.Contains(wd, "rollouts") {
-			// Find the rollouts root by walking up the directory tree
-			dir := wd
-			for {
-				if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
-					repoDir = dir
-					break
-				}
-				parent := filepath.Dir(dir)
-				if parent == dir {
-					// Reached filesystem root, use default
-					break
-				}
-				dir = parent
-			}
-		}
+	if err := secrets.addEntry(ageEntry, recipients); err != nil {
+		return err
 	}
+	return secrets.save()
+}
 
+func runPushCommand(repoDir string, messages []string) {
 	// Header
 	fmt.Println(headerStyle.Render("🚀 Git Push Automation"))
 	fmt.Println(subHeaderStyle.Render("Committing and pushing rollout changes"))
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
+)
+
+// nixLabel is one traefik label, in the order Generate emits it.
+type nixLabel struct {
+	Key   string
+	Value *nixNode
+}
+
+// middlewareLabels returns the labels defining the app's own middlewares, in
+// the order they're chained on its router: requests are filtered first, then
+// authenticated, then rewritten.
+func (c *NixAppConfig) middlewareLabels() []nixLabel {
+	prefix := "traefik.http.middlewares." + c.Name
+	var labels []nixLabel
+	add := func(key string, value *nixNode) {
+		labels = append(labels, nixLabel{prefix + key, value})
+	}
+
+	if len(c.AllowIPs) > 0 {
+		add("-allowip.ipallowlist.sourcerange", nixStr(strings.Join(c.AllowIPs, ",")))
+	}
+	if c.RateLimit != "" {
+		average, period, _ := parseRateLimit(c.RateLimit)
+		add("-ratelimit.ratelimit.average", nixStr(strconv.Itoa(average)))
+		add("-ratelimit.ratelimit.period", nixStr(period))
+		add("-ratelimit.ratelimit.burst", nixStr(strconv.Itoa(average)))
+	}
+	if c.BasicAuth {
+		add("-auth.basicauth.usersfile", nixInterp(nixRef("config", "age", "secrets", nixQuote(basicAuthSecretName(c.Name)), "path")))
+	}
+	if c.SecurityHeaders {
+		add("-headers.headers.stsseconds", nixStr("31536000"))
+		add("-headers.headers.stsincludesubdomains", nixStr("true"))
+		add("-headers.headers.contenttypenosniff", nixStr("true"))
+		add("-headers.headers.browserxssfilter", nixStr("true"))
+		add("-headers.headers.framedeny", nixStr("true"))
+		add("-headers.headers.referrerpolicy", nixStr("strict-origin-when-cross-origin"))
+	}
+	if c.Compress {
+		add("-compress.compress", nixStr("true"))
+	}
+	if c.StripPrefix {
+		add("-stripprefix.stripprefix.prefixes", nixStr(c.PathPrefix))
+	}
+	return labels
+}
+
+// routerMiddlewares lists the middlewares attached to the app's router, in
+// the order traefik applies them.
+func (c *NixAppConfig) routerMiddlewares() []string {
+	prefix := "traefik.http.middlewares."
+	var names []string
+	for _, l := range c.middlewareLabels() {
+		name, _, _ := strings.Cut(strings.TrimPrefix(l.Key, prefix), ".")
+		if len(names) == 0 || names[len(names)-1] != name {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// readMiddlewares fills the middleware settings from the labels found under
+// traefik.http.middlewares.<name>-*. Values are checked afterwards by
+// comparing against what middlewareLabels would emit.
+func (c *NixAppConfig) readMiddlewares(found map[string]string) {
+	prefix := "traefik.http.middlewares." + c.Name
+	has := func(mw string) bool {
+		for key := range found {
+			if strings.HasPrefix(key, prefix+"-"+mw+".") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if v, ok := found[prefix+"-allowip.ipallowlist.sourcerange"]; ok {
+		c.AllowIPs = splitList(v)
+	}
+	if has("ratelimit") {
+		c.RateLimit = formatRateLimit(found[prefix+"-ratelimit.ratelimit.average"], found[prefix+"-ratelimit.ratelimit.period"])
+	}
+	c.BasicAuth = has("auth")
+	c.SecurityHeaders = has("headers")
+	c.Compress = has("compress")
+	c.StripPrefix = has("stripprefix")
+}
+
+var rateLimitPattern = regexp.MustCompile(`^(\d+)/(s|m|h)$`)
+
+// parseRateLimit reads a --rate-limit value such as 100/s into traefik's
+// average and period.
+func parseRateLimit(s string) (int, string, error) {
+	m := rateLimitPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, "", invalid("rate-limit", s, "must be <requests>/<s|m|h>, e.g. 100/s")
+	}
+	average, err := strconv.Atoi(m[1])
+	if err != nil || average < 1 {
+		return 0, "", invalid("rate-limit", s, "must allow at least one request")
+	}
+	return average, "1" + m[2], nil
+}
+
+func formatRateLimit(average, period string) string {
+	return average + "/" + strings.TrimPrefix(period, "1")
+}
+
+func validateRateLimit(s string) error {
+	if s == "" {
+		return nil
+	}
+	_, _, err := parseRateLimit(s)
+	return err
+}
+
+// validateAllowIP checks an --allow-ip entry: a CIDR range or a single IP.
+func validateAllowIP(s string) error {
+	if _, _, err := net.ParseCIDR(s); err == nil {
+		return nil
+	}
+	if net.ParseIP(s) != nil {
+		return nil
+	}
+	return invalid("allow-ip", s, "must be an IP address or CIDR range (e.g., 10.0.0.0/8)")
+}
+
+var basicAuthUserPattern = regexp.MustCompile(`^[A-Za-z0-9._@-]+$`)
+
+func validateBasicAuthUser(user string) error {
+	if !basicAuthUserPattern.MatchString(user) {
+		return invalid("basic-auth", user, "must only contain letters, digits, '.', '_', '@' and '-'")
+	}
+	return nil
+}
+
+// basicAuthSecretName is the agenix secret holding an app's htpasswd file.
+// It lives next to the app's env secret and is readable by traefik.
+func basicAuthSecretName(appName string) string {
+	return appName + "-basic-auth"
+}
+
+// promptBasicAuth asks for a password for user and returns the htpasswd line
+// traefik expects. On a terminal the password is read twice without echo;
+// otherwise a single line is read from stdin so it can be piped in.
+func promptBasicAuth(user string) (string, error) {
+	var password string
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Print(promptStyle.Render("Password for " + user + ": "))
+		first, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return "", err
+		}
+		fmt.Print(promptStyle.Render("Repeat password: "))
+		second, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return "", err
+		}
+		if string(first) != string(second) {
+			return "", fmt.Errorf("passwords do not match")
+		}
+		password = string(first)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("failed to read password from stdin: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	if password == "" {
+		return "", fmt.Errorf("password must not be empty")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return user + ":" + string(hash) + "\n", nil
+}
+
+// mustPromptBasicAuth is promptBasicAuth for commands, exiting on failure.
+func mustPromptBasicAuth(user string) string {
+	htpasswd, err := promptBasicAuth(user)
+	if err != nil {
+		fmt.Println(errorStyle.Render("✗ Failed to set basic auth password: " + err.Error()))
+		os.Exit(1)
+	}
+	return htpasswd
+}
+
+// addBasicAuthSecret registers and encrypts an app's htpasswd secret as part
+// of tx, aborting it on failure.
+func addBasicAuthSecret(tx *Transaction, configDir, appName, htpasswd string) {
+	secret := basicAuthSecretName(appName)
+	entry := ageEntryPath(configDir, secret)
+	secretsPath := secretsNixPath()
+
+	if err := tx.Step("Updating secrets.nix", func() error {
+		if err := tx.Track(secretsPath); err != nil {
+			return err
+		}
+		return updateSecretsNix(entry, secretsPath)
+	}); err != nil {
+		abortTransaction(tx, err)
+	}
+
+	agePath := filepath.Join(configDir, "apps", secret+".age")
+	if err := tx.Step("Encrypting basic auth password", func() error {
+		if err := tx.Track(agePath); err != nil {
+			return err
+		}
+		return encryptSecretData([]byte(htpasswd), entry)
+	}); err != nil {
+		abortTransaction(tx, err)
+	}
+}
+
+// removeBasicAuthSecret deletes an app's htpasswd secret and its secrets.nix
+// entry as part of tx, aborting it on failure.
+func removeBasicAuthSecret(tx *Transaction, configDir, appName string) {
+	secret := basicAuthSecretName(appName)
+	entry := ageEntryPath(configDir, secret)
+	secretsPath := secretsNixPath()
+
+	agePath := filepath.Join(configDir, "apps", secret+".age")
+	if fileExists(agePath) {
+		if err := tx.Step("Deleting "+agePath, func() error { return tx.Remove(agePath) }); err != nil {
+			abortTransaction(tx, err)
+		}
+		fmt.Println(successStyle.Render("✓ Deleted " + agePath))
+	}
+
+	entries, err := loadSecretEntries(secretsPath)
+	if err != nil {
+		abortTransaction(tx, err)
+	}
+	if entries[entry] {
+		if err := tx.Step("Updating secrets.nix", func() error {
+			if err := tx.Track(secretsPath); err != nil {
+				return err
+			}
+			return removeSecretsNixEntry(entry, secretsPath)
+		}); err != nil {
+			abortTransaction(tx, err)
+		}
+		fmt.Println(successStyle.Render("✓ Updated " + secretsPath))
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"strings"
+)
+
+// This file builds Nix source from nixNode trees, so generated files never
+// depend on string templates and every value is escaped on the way out.
+// Attribute path segments are quoted when they aren't plain identifiers;
+// pass a segment through nixQuote to force quoting (app names always are).
+
+// nixStr is a string literal.
+func nixStr(s string) *nixNode {
+	return &nixNode{Kind: nixString, Str: s}
+}
+
+// nixInterp is a string made of literal parts (nixStr) and interpolated
+// expressions, e.g. "${pkgs.docker}/bin/docker".
+func nixInterp(parts ...*nixNode) *nixNode {
+	return &nixNode{Kind: nixString, Items: parts}
+}
+
+// nixRef is an attribute selection such as config.age.secrets."app".path.
+func nixRef(path ...string) *nixNode {
+	return &nixNode{Kind: nixSelect, Path: path}
+}
+
+// nixCall applies the function at path to args, e.g. pkgs.lib.mkForce "x".
+func nixCall(path []string, args ...*nixNode) *nixNode {
+	return &nixNode{Kind: nixApply, Path: path, Items: args}
+}
+
+// nixPathLit is a path literal such as ./app.age.
+func nixPathLit(p string) *nixNode {
+	return &nixNode{Kind: nixPath, Str: p}
+}
+
+// nixListOf is a list rendered on a single line.
+func nixListOf(items ...*nixNode) *nixNode {
+	return &nixNode{Kind: nixList, Items: items}
+}
+
+// nixStrList is a single-line list of string literals.
+func nixStrList(items ...string) *nixNode {
+	list := nixListOf()
+	for _, item := range items {
+		list.Items = append(list.Items, nixStr(item))
+	}
+	return list
+}
+
+// nixAttrSet is an empty attribute set; add bindings with set.
+func nixAttrSet() *nixNode {
+	return &nixNode{Kind: nixAttrs}
+}
+
+// set appends `path = value;` to an attrset and returns the binding so a
+// comment or spacing can be attached.
+func (n *nixNode) set(value *nixNode, path ...string) *nixBinding {
+	b := &nixBinding{Path: path, Value: value}
+	n.Attrs = append(n.Attrs, b)
+	return b
+}
+
+// withComment puts a comment line above the binding.
+func (b *nixBinding) withComment(comment string) *nixBinding {
+	b.Comment = comment
+	return b
+}
+
+// spaced separates the binding from the previous one with a blank line.
+func (b *nixBinding) spaced() *nixBinding {
+	b.BlankBefore = true
+	return b
+}
+
+// nixQuote renders s as a Nix string literal.
+func nixQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '$':
+			if i+1 < len(s) && s[i+1] == '{' {
+				b.WriteString(`\$`)
+			} else {
+				b.WriteByte(c)
+			}
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// nixStringText is a string node as parseNix reads it back: literal parts
+// unescaped and interpolations kept verbatim.
+func nixStringText(n *nixNode) string {
+	if len(n.Items) == 0 {
+		return n.Str
+	}
+	var b strings.Builder
+	for _, part := range n.Items {
+		if part.Kind == nixString {
+			b.WriteString(part.Str)
+			continue
+		}
+		b.WriteString("${")
+		emitNix(&b, part, "")
+		b.WriteByte('}')
+	}
+	return b.String()
+}
+
+// emitNixFile renders `{ args }:` followed by the body expression.
+func emitNixFile(args []string, body *nixNode) string {
+	var b strings.Builder
+	b.WriteString("{ " + strings.Join(args, ", ") + " }:\n")
+	emitNix(&b, body, "")
+	return b.String()
+}
+
+func emitNix(b *strings.Builder, n *nixNode, indent string) {
+	switch n.Kind {
+	case nixString:
+		if len(n.Items) == 0 {
+			b.WriteString(nixQuote(n.Str))
+			return
+		}
+		b.WriteByte('"')
+		for _, part := range n.Items {
+			if part.Kind == nixString {
+				quoted := nixQuote(part.Str)
+				b.WriteString(quoted[1 : len(quoted)-1])
+				continue
+			}
+			b.WriteString("${")
+			emitNix(b, part, indent)
+			b.WriteByte('}')
+		}
+		b.WriteByte('"')
+	case nixPath, nixNumber:
+		b.WriteString(n.Str)
+	case nixSelect:
+		b.WriteString(emitNixAttrPath(n.Path))
+	case nixApply:
+		b.WriteString(emitNixAttrPath(n.Path))
+		for _, arg := range n.Items {
+			b.WriteByte(' ')
+			emitNix(b, arg, indent)
+		}
+	case nixList:
+		if len(n.Items) == 0 {
+			b.WriteString("[ ]")
+			return
+		}
+		if n.Multiline {
+			b.WriteString("[\n")
+			for _, item := range n.Items {
+				b.WriteString(indent + "  ")
+				emitNix(b, item, indent+"  ")
+				b.WriteByte('\n')
+			}
+			b.WriteString(indent + "]")
+			return
+		}
+		b.WriteString("[")
+		for _, item := range n.Items {
+			b.WriteByte(' ')
+			emitNix(b, item, indent)
+		}
+		b.WriteString(" ]")
+	case nixAttrs:
+		if n.Rec {
+			b.WriteString("rec ")
+		}
+		if len(n.Attrs) == 0 {
+			b.WriteString("{ }")
+			return
+		}
+		b.WriteString("{\n")
+		for _, attr := range n.Attrs {
+			if attr.BlankBefore {
+				b.WriteByte('\n')
+			}
+			if attr.Comment != "" {
+				for _, line := range strings.Split(attr.Comment, "\n") {
+					b.WriteString(indent + "  # " + line + "\n")
+				}
+			}
+			b.WriteString(indent + "  " + emitNixAttrPath(attr.Path) + " = ")
+			emitNix(b, attr.Value, indent+"  ")
+			b.WriteString(";\n")
+		}
+		b.WriteString(indent + "}")
+	}
+}
+
+// emitNixAttrPath renders an attribute path. Segments already quoted with
+// nixQuote are kept as-is; others are quoted only when they need to be.
+func emitNixAttrPath(path []string) string {
+	parts := make([]string, len(path))
+	for i, p := range path {
+		switch {
+		case strings.HasPrefix(p, `"`):
+			parts[i] = p
+		case isPlainNixIdent(p):
+			parts[i] = p
+		default:
+			parts[i] = nixQuote(p)
+		}
+	}
+	return strings.Join(parts, ".")
+}
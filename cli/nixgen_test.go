@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files under testdata")
+
+// golden compares got with testdata/name, or rewrites it with -update.
+func golden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs, rerun with -update if intended\n got:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestGenerateGolden(t *testing.T) {
+	useDomain(t, "example.com")
+	for _, app := range testApps {
+		t.Run(app.Name, func(t *testing.T) {
+			golden(t, filepath.Join("apps", app.Name+".nix"), app.Generate())
+		})
+	}
+}
+
+func TestNixQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", `"plain"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\path`, `"C:\\path"`},
+		{"${HOME}", `"\${HOME}"`},
+		{"$HOME costs $5", `"$HOME costs $5"`},
+		{"$${x}", `"$\${x}"`},
+		{"a\nb\r\tc", `"a\nb\r\tc"`},
+		{"", `""`},
+	}
+	for _, tt := range tests {
+		got := nixQuote(tt.in)
+		if got != tt.want {
+			t.Errorf("nixQuote(%q) = %s, want %s", tt.in, got, tt.want)
+			continue
+		}
+		// the parser must read back exactly the original string
+		root, err := parseNix("{ v = " + got + "; }")
+		if err != nil {
+			t.Errorf("parseNix(%s): %v", got, err)
+			continue
+		}
+		if back := nixStringText(root.Attrs[0].Value); back != tt.in {
+			t.Errorf("%s parses back as %q, want %q", got, back, tt.in)
+		}
+	}
+}
+
+func TestEmitNixAttrPath(t *testing.T) {
+	tests := []struct {
+		path []string
+		want string
+	}{
+		{[]string{"age", "secrets"}, "age.secrets"},
+		{[]string{"traefik.enable"}, `"traefik.enable"`},
+		{[]string{"containers", nixQuote("web")}, `containers."web"`},
+		{[]string{"docker-web", "my app"}, `docker-web."my app"`},
+		{[]string{"x", "${y}"}, `x."\${y}"`},
+	}
+	for _, tt := range tests {
+		if got := emitNixAttrPath(tt.path); got != tt.want {
+			t.Errorf("emitNixAttrPath(%q) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestEmitNixFile(t *testing.T) {
+	root := nixAttrSet()
+	root.set(nixStr("nginx"), "services", "web", "image")
+	root.set(nixListOf(), "services", "web", "ports")
+	list := nixStrList("a", "b")
+	list.Multiline = true
+	root.set(list, "opts").spaced().withComment("extra\noptions")
+	root.set(nixInterp(nixRef("pkgs", "docker"), nixStr("/bin/docker pull ${x}")), "pre")
+	root.set(nixCall([]string{"pkgs", "lib", "mkForce"}, nixStr("always")), "restart")
+	root.set(nixPathLit("./web.age"), "file")
+
+	want := `{ config, pkgs, ... }:
+{
+  services.web.image = "nginx";
+  services.web.ports = [ ];
+
+  # extra
+  # options
+  opts = [
+    "a"
+    "b"
+  ];
+  pre = "${pkgs.docker}/bin/docker pull \${x}";
+  restart = pkgs.lib.mkForce "always";
+  file = ./web.age;
+}`
+	if got := emitNixFile([]string{"config", "pkgs", "..."}, root); got != want {
+		t.Errorf("emitNixFile differs\n got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// This file implements a parser for the subset of Nix that rollout generates
+// or reads: a lambda header, let bindings, (rec) attribute sets, lists,
+// strings, paths, numbers and attribute selections, optionally applied to
+// arguments. Anything outside of that subset is a syntax error rather than
+// being guessed at.
+
+type nixKind int
+
+const (
+	nixString nixKind = iota
+	nixPath
+	nixNumber
+	nixSelect
+	nixList
+	nixAttrs
+	nixApply
+	nixLet
+)
+
+func (k nixKind) String() string {
+	switch k {
+	case nixString:
+		return "string"
+	case nixPath:
+		return "path"
+	case nixNumber:
+		return "number"
+	case nixSelect:
+		return "reference"
+	case nixList:
+		return "list"
+	case nixAttrs:
+		return "attribute set"
+	case nixApply:
+		return "function call"
+	case nixLet:
+		return "let expression"
+	}
+	return "value"
+}
+
+// nixNode is a Nix value, either parsed or built for emitting. Str holds the
+// decoded string (with any interpolations kept verbatim as ${...}), the path,
+// the number or the dotted form of a selection; Path holds the segments of a
+// selection. Built strings with interpolations keep their parts in Items;
+// function calls keep the called selection in Str and Path and the arguments
+// in Items; let expressions keep the bindings in Attrs and the body in Items.
+type nixNode struct {
+	Kind      nixKind
+	Str       string
+	Path      []string
+	Items     []*nixNode
+	Attrs     []*nixBinding
+	Rec       bool
+	Multiline bool // emit a list one item per line
+	Line      int
+	Pos, End  int // byte offsets of a parsed value in its source
+}
+
+// nixBinding is a single `a.b."c" = value;` assignment inside an attrset.
+type nixBinding struct {
+	Path        []string
+	Value       *nixNode
+	Line        int
+	Comment     string
+	BlankBefore bool
+	Pos, End    int // byte offsets of a parsed binding, through its ';'
+}
+
+type nixTokenKind int
+
+const (
+	tokEOF nixTokenKind = iota
+	tokPunct
+	tokIdent
+	tokString
+	tokPath
+	tokNumber
+)
+
+type nixToken struct {
+	Kind     nixTokenKind
+	Text     string
+	Line     int
+	Pos, End int // byte offsets in the source
+}
+
+type nixSyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *nixSyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+func lexNix(src string) ([]nixToken, error) {
+	var tokens []nixToken
+	line := 1
+	i := 0
+
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end == -1 {
+				return nil, &nixSyntaxError{line, "unterminated comment"}
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case c == '"':
+			start := line
+			value, n, lines, err := lexNixString(src[i+1:])
+			if err != nil {
+				return nil, &nixSyntaxError{start, err.Error()}
+			}
+			tokens = append(tokens, nixToken{tokString, value, start, i, i + n + 1})
+			line += lines
+			i += n + 1
+		case strings.HasPrefix(src[i:], "''"):
+			return nil, &nixSyntaxError{line, "indented strings are not supported"}
+		case strings.HasPrefix(src[i:], "..."):
+			tokens = append(tokens, nixToken{tokPunct, "...", line, i, i + 3})
+			i += 3
+		case strings.HasPrefix(src[i:], "./") || strings.HasPrefix(src[i:], "../") || (c == '/' && i+1 < len(src) && isNixPathChar(src[i+1])):
+			j := i
+			for j < len(src) && (isNixPathChar(src[j]) || src[j] == '/') {
+				j++
+			}
+			tokens = append(tokens, nixToken{tokPath, src[i:j], line, i, j})
+			i = j
+		case strings.ContainsRune("{}[]=;:,.?@", rune(c)):
+			tokens = append(tokens, nixToken{tokPunct, string(c), line, i, i + 1})
+			i++
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && src[j] >= '0' && src[j] <= '9' {
+				j++
+			}
+			tokens = append(tokens, nixToken{tokNumber, src[i:j], line, i, j})
+			i = j
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(src) && isNixIdentChar(src[j]) {
+				j++
+			}
+			tokens = append(tokens, nixToken{tokIdent, src[i:j], line, i, j})
+			i = j
+		default:
+			return nil, &nixSyntaxError{line, fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+
+	tokens = append(tokens, nixToken{tokEOF, "", line, len(src), len(src)})
+	return tokens, nil
+}
+
+// lexNixString decodes a double-quoted string body (after the opening quote).
+// It returns the decoded value, the number of bytes consumed including the
+// closing quote, and the number of newlines crossed.
+func lexNixString(src string) (string, int, int, error) {
+	var b strings.Builder
+	lines := 0
+	i := 0
+
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '"':
+			return b.String(), i + 1, lines, nil
+		case c == '\\':
+			if i+1 >= len(src) {
+				return "", 0, 0, fmt.Errorf("unterminated string")
+			}
+			switch next := src[i+1]; next {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			default:
+				if next == '\n' {
+					lines++
+				}
+				b.WriteByte(next)
+			}
+			i += 2
+		case strings.HasPrefix(src[i:], "${"):
+			// keep interpolations verbatim; they are only ever compared
+			depth := 0
+			j := i + 1
+			for ; j < len(src); j++ {
+				if src[j] == '{' {
+					depth++
+				} else if src[j] == '}' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				} else if src[j] == '\n' {
+					lines++
+				}
+			}
+			if j >= len(src) {
+				return "", 0, 0, fmt.Errorf("unterminated interpolation")
+			}
+			b.WriteString(src[i : j+1])
+			i = j + 1
+		default:
+			if c == '\n' {
+				lines++
+			}
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	return "", 0, 0, fmt.Errorf("unterminated string")
+}
+
+func isNixIdentChar(c byte) bool {
+	return c == '_' || c == '-' || c == '\'' || (c >= '0' && c <= '9') || unicode.IsLetter(rune(c))
+}
+
+func isNixPathChar(c byte) bool {
+	return c == '.' || c == '_' || c == '-' || c == '+' || (c >= '0' && c <= '9') || unicode.IsLetter(rune(c))
+}
+
+type nixParser struct {
+	tokens []nixToken
+	pos    int
+}
+
+// parseNix parses a Nix file of the form `{ args, ... }: <expr>` or a bare
+// expression, returning the body expression.
+func parseNix(src string) (*nixNode, error) {
+	tokens, err := lexNix(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &nixParser{tokens: tokens}
+	if p.atFormals() {
+		if err := p.skipFormals(); err != nil {
+			return nil, err
+		}
+	}
+
+	body, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.Kind != tokEOF {
+		return nil, p.unexpected(tok)
+	}
+
+	return body, nil
+}
+
+func (p *nixParser) peek() nixToken {
+	return p.tokens[p.pos]
+}
+
+func (p *nixParser) peekAt(offset int) nixToken {
+	if p.pos+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *nixParser) next() nixToken {
+	tok := p.tokens[p.pos]
+	if tok.Kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *nixParser) isPunct(text string) bool {
+	tok := p.peek()
+	return tok.Kind == tokPunct && tok.Text == text
+}
+
+func (p *nixParser) expect(text string) error {
+	if !p.isPunct(text) {
+		return p.unexpected(p.peek())
+	}
+	p.next()
+	return nil
+}
+
+func (p *nixParser) unexpected(tok nixToken) error {
+	if tok.Kind == tokEOF {
+		return &nixSyntaxError{tok.Line, "unexpected end of file"}
+	}
+	return &nixSyntaxError{tok.Line, fmt.Sprintf("unexpected %q", tok.Text)}
+}
+
+// atFormals reports whether the parser is looking at a lambda's `{ a, b, ... }:`
+// argument set rather than an attrset.
+func (p *nixParser) atFormals() bool {
+	if !p.isPunct("{") {
+		return false
+	}
+	first, second := p.peekAt(1), p.peekAt(2)
+	if first.Kind == tokPunct && first.Text == "..." {
+		return true
+	}
+	if first.Kind == tokPunct && first.Text == "}" {
+		return second.Kind == tokPunct && second.Text == ":"
+	}
+	return first.Kind == tokIdent && second.Kind == tokPunct &&
+		(second.Text == "," || second.Text == "}" || second.Text == "?")
+}
+
+func (p *nixParser) skipFormals() error {
+	for !p.isPunct("}") {
+		if p.peek().Kind == tokEOF {
+			return p.unexpected(p.peek())
+		}
+		if p.isPunct("?") {
+			return &nixSyntaxError{p.peek().Line, "default values in function arguments are not supported"}
+		}
+		p.next()
+	}
+	p.next()
+	return p.expect(":")
+}
+
+// parseExpr parses a value, including a function call such as
+// `pkgs.lib.mkForce "x"` and a `let ... in` expression.
+func (p *nixParser) parseExpr() (node *nixNode, err error) {
+	defer p.span(&node, p.peek().Pos)
+	if tok := p.peek(); tok.Kind == tokIdent && tok.Text == "let" {
+		return p.parseLet()
+	}
+	node, err = p.parseValue()
+	if err != nil || node.Kind != nixSelect {
+		return node, err
+	}
+	for p.atValue() {
+		arg, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		node.Kind = nixApply
+		node.Items = append(node.Items, arg)
+	}
+	return node, nil
+}
+
+// span records the source offsets of a parsed node, from pos to the end of
+// the last token consumed.
+func (p *nixParser) span(node **nixNode, pos int) {
+	if *node != nil {
+		(*node).Pos = pos
+		(*node).End = p.tokens[p.pos-1].End
+	}
+}
+
+// atValue reports whether the next token starts a value, i.e. an argument.
+func (p *nixParser) atValue() bool {
+	tok := p.peek()
+	switch tok.Kind {
+	case tokString, tokPath, tokNumber, tokIdent:
+		return true
+	case tokPunct:
+		return tok.Text == "{" || tok.Text == "["
+	}
+	return false
+}
+
+// parseValue parses a single value without function application, as used for
+// list items and arguments.
+func (p *nixParser) parseValue() (node *nixNode, err error) {
+	tok := p.peek()
+	defer p.span(&node, tok.Pos)
+
+	switch tok.Kind {
+	case tokString:
+		p.next()
+		return &nixNode{Kind: nixString, Str: tok.Text, Line: tok.Line}, nil
+	case tokPath:
+		p.next()
+		return &nixNode{Kind: nixPath, Str: tok.Text, Line: tok.Line}, nil
+	case tokNumber:
+		p.next()
+		return &nixNode{Kind: nixNumber, Str: tok.Text, Line: tok.Line}, nil
+	case tokIdent:
+		if tok.Text == "rec" && p.peekAt(1).Kind == tokPunct && p.peekAt(1).Text == "{" {
+			p.next()
+			node, err := p.parseAttrs()
+			if err != nil {
+				return nil, err
+			}
+			node.Rec = true
+			return node, nil
+		}
+		path, err := p.parseAttrPath()
+		if err != nil {
+			return nil, err
+		}
+		return &nixNode{Kind: nixSelect, Str: formatNixPath(path), Path: path, Line: tok.Line}, nil
+	case tokPunct:
+		switch tok.Text {
+		case "{":
+			return p.parseAttrs()
+		case "[":
+			return p.parseList()
+		}
+	}
+
+	return nil, p.unexpected(tok)
+}
+
+func (p *nixParser) parseList() (*nixNode, error) {
+	start := p.next()
+	node := &nixNode{Kind: nixList, Line: start.Line}
+
+	for !p.isPunct("]") {
+		item, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		node.Items = append(node.Items, item)
+	}
+	p.next()
+
+	return node, nil
+}
+
+func (p *nixParser) parseAttrs() (*nixNode, error) {
+	start := p.next()
+	node := &nixNode{Kind: nixAttrs, Line: start.Line}
+	if err := p.parseBindings(node, func() bool { return p.isPunct("}") }); err != nil {
+		return nil, err
+	}
+	p.next()
+
+	return node, nil
+}
+
+func (p *nixParser) parseLet() (*nixNode, error) {
+	start := p.next()
+	node := &nixNode{Kind: nixLet, Line: start.Line}
+	atIn := func() bool {
+		tok := p.peek()
+		return tok.Kind == tokIdent && tok.Text == "in"
+	}
+	if err := p.parseBindings(node, atIn); err != nil {
+		return nil, err
+	}
+	p.next()
+
+	body, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	node.Items = []*nixNode{body}
+	return node, nil
+}
+
+// parseBindings reads `path = value;` bindings into node until done reports
+// the closing token, which is left for the caller.
+func (p *nixParser) parseBindings(node *nixNode, done func() bool) error {
+	for !done() {
+		if p.peek().Kind == tokEOF {
+			return p.unexpected(p.peek())
+		}
+		line, pos := p.peek().Line, p.peek().Pos
+		if p.peek().Kind == tokIdent && p.peek().Text == "inherit" {
+			return &nixSyntaxError{line, "inherit is not supported"}
+		}
+		path, err := p.parseAttrPath()
+		if err != nil {
+			return err
+		}
+		if err := p.expect("="); err != nil {
+			return err
+		}
+		value, err := p.parseExpr()
+		if err != nil {
+			return err
+		}
+		if err := p.expect(";"); err != nil {
+			return err
+		}
+		node.Attrs = append(node.Attrs, &nixBinding{Path: path, Value: value, Line: line, Pos: pos, End: p.tokens[p.pos-1].End})
+	}
+	return nil
+}
+
+func (p *nixParser) parseAttrPath() ([]string, error) {
+	var path []string
+	for {
+		tok := p.next()
+		if tok.Kind != tokIdent && tok.Kind != tokString {
+			return nil, p.unexpected(tok)
+		}
+		if tok.Kind == tokString && strings.Contains(tok.Text, "${") {
+			return nil, &nixSyntaxError{tok.Line, "interpolated attribute names are not supported"}
+		}
+		path = append(path, tok.Text)
+		if !p.isPunct(".") {
+			return path, nil
+		}
+		p.next()
+	}
+}
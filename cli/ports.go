@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/spf13/cobra"
+)
+
+func newPortsCmd(configDir *string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ports",
+		Short: "inspect and reconcile the ports.json registry",
+	}
+
+	cmd.AddCommand(newPortsListCmd(configDir))
+	cmd.AddCommand(newPortsGCCmd(configDir))
+	cmd.AddCommand(newPortsReserveCmd(configDir))
+	cmd.AddCommand(newPortsReleaseCmd(configDir))
+	cmd.AddCommand(newPortsRebuildCmd(configDir))
+
+	return cmd
+}
+
+// PortAllocation is one row of `rollout ports list`.
+type PortAllocation struct {
+	Name    string `json:"name"`
+	Port    int    `json:"port"`
+	HasApp  bool   `json:"has_app"`
+	InRange bool   `json:"in_range"`
+}
+
+func newPortsListCmd(configDir *string) *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "list port allocations",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			registry := mustLoadPortRegistry(*configDir)
+
+			allocations := make([]PortAllocation, 0, len(registry.Allocations))
+			for name, port := range registry.Allocations {
+				allocations = append(allocations, PortAllocation{
+					Name:    name,
+					Port:    port,
+					HasApp:  fileExists(appFilePath(*configDir, name)),
+					InRange: port >= PortRangeStart && port <= PortRangeEnd,
+				})
+			}
+			sort.Slice(allocations, func(i, j int) bool { return allocations[i].Port < allocations[j].Port })
+
+			switch output {
+			case "json":
+				data, err := json.MarshalIndent(struct {
+					Allocations []PortAllocation `json:"allocations"`
+					NextPort    int              `json:"next_port"`
+				}{allocations, registry.NextPort}, "", "  ")
+				if err != nil {
+					fmt.Println(errorStyle.Render("✗ Failed to encode ports: " + err.Error()))
+					os.Exit(1)
+				}
+				fmt.Println(string(data))
+			case "table":
+				printPortTable(allocations, registry.NextPort)
+			default:
+				fmt.Println(errorStyle.Render("✗ Unknown output format: " + output))
+				fmt.Println(mutedStyle.Render("Use one of: table, json"))
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "table", "output format (table or json)")
+
+	return cmd
+}
+
+func printPortTable(allocations []PortAllocation, nextPort int) {
+	if len(allocations) == 0 {
+		fmt.Println(mutedStyle.Render("ℹ️ No ports allocated"))
+	} else {
+		rows := make([][]string, 0, len(allocations))
+		for _, a := range allocations {
+			status := "ok"
+			switch {
+			case !a.HasApp:
+				status = "no app file"
+			case !a.InRange:
+				status = "out of range"
+			}
+			rows = append(rows, []string{strconv.Itoa(a.Port), a.Name, status})
+		}
+
+		t := table.New().
+			Border(lipgloss.RoundedBorder()).
+			BorderStyle(lipgloss.NewStyle().Foreground(borderColor)).
+			Headers("PORT", "APP", "STATUS").
+			Rows(rows...).
+			StyleFunc(func(row, col int) lipgloss.Style {
+				if row == table.HeaderRow {
+					return headerStyle.Padding(0, 1)
+				}
+				return inputStyle.Padding(0, 1)
+			})
+		fmt.Println(t)
+	}
+	fmt.Println(mutedStyle.Render(fmt.Sprintf("Next port: %d (range %d-%d)", nextPort, PortRangeStart, PortRangeEnd)))
+}
+
+func newPortsGCCmd(configDir *string) *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "drop allocations that have no app file",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !dryRun {
+				lock := mustLockConfigDir(*configDir)
+				defer lock.Unlock()
+			}
+			registry := mustLoadPortRegistry(*configDir)
+
+			names := make([]string, 0)
+			for name := range registry.Allocations {
+				if !fileExists(appFilePath(*configDir, name)) {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+
+			if len(names) == 0 {
+				fmt.Println(successStyle.Render("✓ No stale allocations"))
+				return
+			}
+			for _, name := range names {
+				fmt.Println(fmt.Sprintf("• Release %s (port %d)", name, registry.Allocations[name]))
+				delete(registry.Allocations, name)
+			}
+			if dryRun {
+				fmt.Println(mutedStyle.Render("ℹ️ Dry run - no changes made"))
+				return
+			}
+
+			resetNextPort(registry)
+			mustSavePortRegistry(registry, *configDir)
+			fmt.Println(successStyle.Render(fmt.Sprintf("✓ Released %d stale allocation(s)", len(names))))
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print what would be released without changing ports.json")
+
+	return cmd
+}
+
+func newPortsReserveCmd(configDir *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "reserve <name> <port>",
+		Short: "allocate a specific host port to a name",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := args[0]
+			port, err := strconv.Atoi(args[1])
+			if err != nil {
+				fmt.Println(errorStyle.Render("✗ " + invalid("port", args[1], "must be a number").Error()))
+				os.Exit(1)
+			}
+			exitOnValidationErrors(nonNil(validateName(name), validatePortInRange(port)))
+
+			lock := mustLockConfigDir(*configDir)
+			defer lock.Unlock()
+			registry := mustLoadPortRegistry(*configDir)
+
+			if current, ok := registry.Allocations[name]; ok {
+				if current == port {
+					fmt.Println(mutedStyle.Render(fmt.Sprintf("ℹ️ %s already holds port %d", name, port)))
+					return
+				}
+				fmt.Println(errorStyle.Render(fmt.Sprintf("✗ %s already holds port %d; release it first", name, current)))
+				os.Exit(1)
+			}
+			for other, p := range registry.Allocations {
+				if p == port {
+					fmt.Println(errorStyle.Render(fmt.Sprintf("✗ Port %d is already allocated to %s", port, other)))
+					os.Exit(1)
+				}
+			}
+
+			registry.Allocations[name] = port
+			if port >= registry.NextPort {
+				registry.NextPort = port + 1
+			}
+			mustSavePortRegistry(registry, *configDir)
+			fmt.Println(successStyle.Render(fmt.Sprintf("✓ Reserved port %d for %s", port, name)))
+		},
+	}
+}
+
+func newPortsReleaseCmd(configDir *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "release <name>",
+		Short: "drop a name's port allocation",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := args[0]
+			mustValidateAppArg(name)
+
+			lock := mustLockConfigDir(*configDir)
+			defer lock.Unlock()
+			registry := mustLoadPortRegistry(*configDir)
+
+			port, ok := registry.Allocations[name]
+			if !ok {
+				fmt.Println(errorStyle.Render("✗ No port allocated to " + name))
+				os.Exit(1)
+			}
+			if fileExists(appFilePath(*configDir, name)) {
+				fmt.Println(mutedStyle.Render(fmt.Sprintf("⚠️ apps/%s.nix still binds port %d", name, port)))
+			}
+
+			delete(registry.Allocations, name)
+			resetNextPort(registry)
+			mustSavePortRegistry(registry, *configDir)
+			fmt.Println(successStyle.Render(fmt.Sprintf("✓ Released port %d from %s", port, name)))
+		},
+	}
+}
+
+func newPortsRebuildCmd(configDir *string) *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "rebuild",
+		Short: "regenerate ports.json from the app files",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !dryRun {
+				lock := mustLockConfigDir(*configDir)
+				defer lock.Unlock()
+			}
+
+			registry := &PortRegistry{
+				Allocations: make(map[string]int),
+				NextPort:    PortRangeStart,
+			}
+			if err := initializeRegistryFromExistingApps(registry, *configDir); err != nil {
+				fmt.Println(errorStyle.Render("✗ Failed to scan apps: " + err.Error()))
+				os.Exit(1)
+			}
+
+			if dryRun {
+				data, _ := json.MarshalIndent(registry, "", "  ")
+				fmt.Println(string(data))
+				return
+			}
+
+			mustSavePortRegistry(registry, *configDir)
+			fmt.Println(successStyle.Render(fmt.Sprintf("✓ Rebuilt ports.json with %d allocation(s)", len(registry.Allocations))))
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the rebuilt registry without writing it")
+
+	return cmd
+}
+
+// resetNextPort moves NextPort back to just past the highest allocation.
+func resetNextPort(registry *PortRegistry) {
+	registry.NextPort = PortRangeStart
+	for _, port := range registry.Allocations {
+		if port >= registry.NextPort {
+			registry.NextPort = port + 1
+		}
+	}
+}
+
+func validatePortInRange(port int) error {
+	if port < PortRangeStart || port > PortRangeEnd {
+		return invalid("port", strconv.Itoa(port), "must be between %d and %d", PortRangeStart, PortRangeEnd)
+	}
+	return nil
+}
+
+// nonNil drops nil errors, for passing single checks to exitOnValidationErrors.
+func nonNil(errs ...error) []error {
+	out := errs[:0]
+	for _, err := range errs {
+		if err != nil {
+			out = append(out, err)
+		}
+	}
+	return out
+}
+
+func appFilePath(configDir, name string) string {
+	return filepath.Join(configDir, "apps", fmt.Sprintf("%s.nix", name))
+}
+
+func mustLoadPortRegistry(configDir string) *PortRegistry {
+	registry, err := loadPortRegistry(configDir)
+	if err != nil {
+		fmt.Println(errorStyle.Render("✗ Failed to load port registry: " + err.Error()))
+		os.Exit(1)
+	}
+	return registry
+}
+
+func mustSavePortRegistry(registry *PortRegistry, configDir string) {
+	if err := savePortRegistry(registry, configDir); err != nil {
+		fmt.Println(errorStyle.Render("✗ Failed to save port registry: " + err.Error()))
+		os.Exit(1)
+	}
+}
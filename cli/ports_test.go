@@ -0,0 +1,102 @@
+package main
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestAllocatePort(t *testing.T) {
+	tests := []struct {
+		name        string
+		allocations map[string]int
+		nextPort    int
+		app         string
+		wantPort    int
+		wantNext    int
+	}{
+		{"empty registry", map[string]int{}, 10000, "web", 10000, 10001},
+		{"after the last", map[string]int{"a": 10000, "b": 10001}, 10002, "web", 10002, 10003},
+		{"reuses a freed port", map[string]int{"a": 10000, "c": 10002}, 10003, "web", 10001, 10003},
+		{"reuses the first port", map[string]int{"b": 10001}, 10002, "web", 10000, 10002},
+		{"keeps an existing allocation", map[string]int{"web": 10005}, 10006, "web", 10005, 10006},
+		{"skips a port reserved past NextPort", map[string]int{"a": 10000, "reserved": 10001}, 10001, "web", 10002, 10003},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := &PortRegistry{Allocations: maps.Clone(tt.allocations), NextPort: tt.nextPort}
+			port, err := allocatePort(registry, tt.app)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if port != tt.wantPort {
+				t.Errorf("port = %d, want %d", port, tt.wantPort)
+			}
+			if registry.Allocations[tt.app] != tt.wantPort {
+				t.Errorf("allocations[%s] = %d, want %d", tt.app, registry.Allocations[tt.app], tt.wantPort)
+			}
+			if registry.NextPort != tt.wantNext {
+				t.Errorf("NextPort = %d, want %d", registry.NextPort, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestAllocatePortExhausted(t *testing.T) {
+	savedEnd := PortRangeEnd
+	t.Cleanup(func() { PortRangeEnd = savedEnd })
+	PortRangeEnd = PortRangeStart + 1
+
+	registry := &PortRegistry{Allocations: map[string]int{"a": PortRangeStart, "b": PortRangeStart + 1}}
+	_, err := allocatePort(registry, "web")
+	if err == nil || !strings.Contains(err.Error(), "no available ports") {
+		t.Fatalf("error = %v, want no available ports", err)
+	}
+	if _, ok := registry.Allocations["web"]; ok {
+		t.Error("web was allocated a port anyway")
+	}
+}
+
+func TestResetNextPort(t *testing.T) {
+	tests := []struct {
+		allocations map[string]int
+		want        int
+	}{
+		{map[string]int{}, PortRangeStart},
+		{map[string]int{"a": 10000, "b": 10007}, 10008},
+		{map[string]int{"a": 10003}, 10004},
+	}
+	for _, tt := range tests {
+		registry := &PortRegistry{Allocations: tt.allocations, NextPort: 12000}
+		resetNextPort(registry)
+		if registry.NextPort != tt.want {
+			t.Errorf("resetNextPort(%v) NextPort = %d, want %d", tt.allocations, registry.NextPort, tt.want)
+		}
+	}
+}
+
+func TestLoadPortRegistryFromApps(t *testing.T) {
+	configDir := testConfigDir(t)
+	site := testApps[0]
+	site.HostPort = 10004
+	writeApp(t, configDir, site)
+	writeApp(t, configDir, testApps[3]) // internal, binds nothing
+	// a file the parser rejects keeps its port through the fallback scan
+	writeTestFile(t, appFilePath(configDir, "broken"), `{ ports = [ "127.0.0.1:10002:80" ]; `, 0o644)
+
+	registry, err := loadPortRegistry(configDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"site": 10004, "broken": 10002}; !maps.Equal(registry.Allocations, want) {
+		t.Errorf("allocations = %v, want %v", registry.Allocations, want)
+	}
+	if registry.NextPort != 10005 {
+		t.Errorf("NextPort = %d, want 10005", registry.NextPort)
+	}
+
+	// the freed ports below the highest are handed out first
+	if port, err := allocatePort(registry, "web"); err != nil || port != 10000 {
+		t.Errorf("allocatePort = %d, %v, want 10000", port, err)
+	}
+}
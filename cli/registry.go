@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Docker Hub is addressed as docker.io in image names, but serves the
+// distribution API from registry-1.docker.io.
+const (
+	dockerHubName     = "docker.io"
+	dockerHubRegistry = "registry-1.docker.io"
+	dockerHubAuthKey  = "https://index.docker.io/v1/"
+)
+
+// manifestMediaTypes are the manifests we accept when resolving a tag. Index
+// types come first so multi-arch images pin to the index rather than to
+// whichever platform the registry picks.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+var registryClient = &http.Client{Timeout: 30 * time.Second}
+
+// imageRef is an image reference split into the parts the distribution API
+// needs.
+type imageRef struct {
+	Registry   string // host[:port] serving the API
+	Repository string // e.g. library/nginx
+	Tag        string
+	Digest     string
+}
+
+// splitImageDigest splits name[:tag]@digest into its name and digest.
+func splitImageDigest(image string) (name, digest string) {
+	name, digest, _ = strings.Cut(image, "@")
+	return name, digest
+}
+
+// isPinned reports whether the image is referenced by digest.
+func isPinned(image string) bool {
+	_, digest := splitImageDigest(image)
+	return digest != ""
+}
+
+// pinImage returns image pinned to digest, keeping its tag so it can be
+// resolved again by `rollout images update`.
+func pinImage(image, digest string) string {
+	name, _ := splitImageDigest(image)
+	return name + "@" + digest
+}
+
+// parseImageRef splits an image reference the way docker does: the first
+// path component is a registry only if it looks like a host, and single
+// component Docker Hub images live under library/.
+func parseImageRef(image string) imageRef {
+	name, digest := splitImageDigest(image)
+	ref := imageRef{Digest: digest, Tag: "latest"}
+
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+	}
+
+	first, rest, ok := strings.Cut(name, "/")
+	if ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.Registry, ref.Repository = first, rest
+	} else {
+		ref.Registry, ref.Repository = dockerHubName, name
+	}
+	if ref.Registry == dockerHubName {
+		ref.Registry = dockerHubRegistry
+		if !strings.Contains(ref.Repository, "/") {
+			ref.Repository = "library/" + ref.Repository
+		}
+	}
+	return ref
+}
+
+// baseURL is the registry's API root. Registries on the loopback interface,
+// such as a local registry:2, are spoken to over plain HTTP.
+func (r imageRef) baseURL() string {
+	host := r.Registry
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return "http://" + r.Registry
+	}
+	return "https://" + r.Registry
+}
+
+// resolveDigest asks the image's registry for the digest its tag currently
+// points to.
+func resolveDigest(image string) (string, error) {
+	ref := parseImageRef(image)
+	manifestURL := fmt.Sprintf("%s/v2/%s/manifests/%s", ref.baseURL(), ref.Repository, ref.Tag)
+
+	// HEAD is enough when the registry sends Docker-Content-Digest, and
+	// doesn't count against Docker Hub's pull limits
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		resp, err := registryRequest(ref, method, manifestURL)
+		if err != nil {
+			return "", err
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+		resp.Body.Close()
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			return "", fmt.Errorf("%s: tag %q not found", image, ref.Tag)
+		case resp.StatusCode != http.StatusOK:
+			if method == http.MethodHead {
+				continue
+			}
+			return "", fmt.Errorf("%s: registry returned %s", image, resp.Status)
+		}
+
+		if digest := resp.Header.Get("Docker-Content-Digest"); digestPattern.MatchString(digest) {
+			return digest, nil
+		}
+		if method == http.MethodGet {
+			sum := sha256.Sum256(body)
+			return "sha256:" + hex.EncodeToString(sum[:]), nil
+		}
+	}
+	return "", fmt.Errorf("%s: registry did not return a digest", image)
+}
+
+var digestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// registryRequest sends an API request, answering a 401 challenge with a
+// bearer token or basic credentials as the registry asks.
+func registryRequest(ref imageRef, method, target string) (*http.Response, error) {
+	do := func(auth string) (*http.Response, error) {
+		req, err := http.NewRequest(method, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		return registryClient.Do(req)
+	}
+
+	resp, err := do("")
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	scheme, params := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	user, password := registryCredentials(ref.Registry)
+	switch strings.ToLower(scheme) {
+	case "bearer":
+		token, err := fetchRegistryToken(ref, params, user, password)
+		if err != nil {
+			return nil, err
+		}
+		return do("Bearer " + token)
+	case "basic":
+		if user == "" {
+			return nil, fmt.Errorf("%s requires credentials; run `docker login %s`", ref.Registry, ref.Registry)
+		}
+		return do("Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password)))
+	}
+	return nil, fmt.Errorf("%s: unsupported auth challenge %q", ref.Registry, scheme)
+}
+
+// fetchRegistryToken gets a pull token from the realm named in a bearer
+// challenge, anonymously unless docker has credentials for the registry.
+func fetchRegistryToken(ref imageRef, params map[string]string, user, password string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("%s: invalid token realm %q", ref.Registry, params["realm"])
+	}
+	q := realm.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	q.Set("scope", "repository:"+ref.Repository+":pull")
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+	resp, err := registryClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: token request returned %s", ref.Registry, resp.Status)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("%s: invalid token response: %w", ref.Registry, err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", fmt.Errorf("%s: token response had no token", ref.Registry)
+}
+
+var challengeParamPattern = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// parseChallenge splits a WWW-Authenticate header such as
+// `Bearer realm="...",service="..."` into its scheme and parameters.
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := map[string]string{}
+	for _, m := range challengeParamPattern.FindAllStringSubmatch(rest, -1) {
+		params[m[1]] = m[2]
+	}
+	return scheme, params
+}
+
+// registryCredentials reads the user and password `docker login` stored for
+// a registry in the docker config. Credential helpers aren't supported, so
+// anything not in "auths" is treated as anonymous.
+func registryCredentials(registry string) (string, string) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", ""
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return "", ""
+	}
+
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if json.Unmarshal(content, &config) != nil {
+		return "", ""
+	}
+
+	key := registry
+	if registry == dockerHubRegistry {
+		key = dockerHubAuthKey
+	}
+	for _, k := range []string{key, "https://" + key} {
+		if entry, ok := config.Auths[k]; ok {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return "", ""
+			}
+			user, password, _ := strings.Cut(string(decoded), ":")
+			return user, password
+		}
+	}
+	return "", ""
+}
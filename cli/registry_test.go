@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		image string
+		want  imageRef
+	}{
+		{"nginx", imageRef{"registry-1.docker.io", "library/nginx", "latest", ""}},
+		{"kabilan108/site:1.2", imageRef{"registry-1.docker.io", "kabilan108/site", "1.2", ""}},
+		{"ghcr.io/kabilan108/tools:main@" + testDigest, imageRef{"ghcr.io", "kabilan108/tools", "main", testDigest}},
+		{"ghcr.io/kabilan108/tools@" + testDigest, imageRef{"ghcr.io", "kabilan108/tools", "latest", testDigest}},
+		{"localhost:5000/app", imageRef{"localhost:5000", "app", "latest", ""}},
+		{"registry:5000/team/app:v1", imageRef{"registry:5000", "team/app", "v1", ""}},
+	}
+	for _, tt := range tests {
+		if got := parseImageRef(tt.image); got != tt.want {
+			t.Errorf("parseImageRef(%q) = %+v, want %+v", tt.image, got, tt.want)
+		}
+	}
+}
+
+// testRegistry stands in for a registry:2 serving team/app:v1 with manifest.
+// With token set, manifest requests need it as a bearer token from /token.
+func testRegistry(t *testing.T, manifest, token string, headDigest bool) *httptest.Server {
+	t.Helper()
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if got := r.URL.Query().Get("scope"); got != "repository:team/app:pull" {
+				t.Errorf("token scope = %q", got)
+			}
+			w.Write([]byte(`{"token":"` + token + `"}`))
+		case "/v2/team/app/manifests/v1":
+			if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="test"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.Method == http.MethodHead {
+				if !headDigest {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+				w.Header().Set("Docker-Content-Digest", testDigest)
+				return
+			}
+			w.Write([]byte(manifest))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testImage(srv *httptest.Server, tag string) string {
+	return strings.TrimPrefix(srv.URL, "http://") + "/team/app:" + tag
+}
+
+func TestResolveDigestFromHead(t *testing.T) {
+	srv := testRegistry(t, `{"schemaVersion":2}`, "", true)
+	got, err := resolveDigest(testImage(srv, "v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != testDigest {
+		t.Fatalf("digest = %s, want %s", got, testDigest)
+	}
+}
+
+func TestResolveDigestFallsBackToGet(t *testing.T) {
+	manifest := `{"schemaVersion":2}`
+	srv := testRegistry(t, manifest, "", false)
+	got, err := resolveDigest(testImage(srv, "v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(manifest))
+	if want := "sha256:" + hex.EncodeToString(sum[:]); got != want {
+		t.Fatalf("digest = %s, want %s", got, want)
+	}
+}
+
+func TestResolveDigestWithBearerToken(t *testing.T) {
+	srv := testRegistry(t, `{"schemaVersion":2}`, "pull-token", true)
+	got, err := resolveDigest(testImage(srv, "v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != testDigest {
+		t.Fatalf("digest = %s, want %s", got, testDigest)
+	}
+}
+
+func TestResolveDigestUnknownTag(t *testing.T) {
+	srv := testRegistry(t, `{"schemaVersion":2}`, "", true)
+	_, err := resolveDigest(testImage(srv, "v2"))
+	if err == nil || !strings.Contains(err.Error(), `tag "v2" not found`) {
+		t.Fatalf("error = %v, want tag not found", err)
+	}
+}
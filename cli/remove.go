@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newRemoveCmd(configDir *string) *cobra.Command {
+	var (
+		dryRun bool
+		yes    bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "remove <app>",
+		Short: "remove an app's config, secret, port allocation and secrets.nix entry",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runRemoveCommand(*configDir, args[0], dryRun, yes)
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the planned changes without applying them")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "skip the confirmation prompt")
+
+	return cmd
+}
+
+func runRemoveCommand(configDir, appName string, dryRun, yes bool) {
+	mustValidateAppArg(appName)
+
+	appsDir := filepath.Join(configDir, "apps")
+	nixPath := filepath.Join(appsDir, fmt.Sprintf("%s.nix", appName))
+	agePath := filepath.Join(appsDir, fmt.Sprintf("%s.age", appName))
+	secretsPath := secretsNixPath()
+
+	if !dryRun {
+		lock := mustLockConfigDir(configDir)
+		defer lock.Unlock()
+	}
+
+	registry, err := loadPortRegistry(configDir)
+	if err != nil {
+		fmt.Println(errorStyle.Render("✗ Failed to load port registry: " + err.Error()))
+		os.Exit(1)
+	}
+
+	secrets, err := loadSecretEntries(secretsPath)
+	if err != nil {
+		fmt.Println(errorStyle.Render("✗ Failed to read secrets.nix: " + err.Error()))
+		os.Exit(1)
+	}
+
+	schemas, err := loadEnvSchemas(configDir)
+	if err != nil {
+		fmt.Println(errorStyle.Render("✗ " + err.Error()))
+		os.Exit(1)
+	}
+
+	// work out what actually exists so the plan only lists real changes
+	plan := []string{}
+	hasNix := fileExists(nixPath)
+	if hasNix {
+		plan = append(plan, "Delete "+nixPath)
+	}
+	hasAge := fileExists(agePath)
+	if hasAge {
+		plan = append(plan, "Delete "+agePath)
+	}
+	port, hasPort := registry.Allocations[appName]
+	if hasPort {
+		plan = append(plan, fmt.Sprintf("Release host port %d in ports.json", port))
+	}
+	_, hasSchema := schemas.Apps[appName]
+	if hasSchema {
+		plan = append(plan, "Remove its env schema from env-schema.json")
+	}
+	ageEntry := ageEntryPath(configDir, appName)
+	hasSecretEntry := secrets[ageEntry]
+	if hasSecretEntry {
+		plan = append(plan, fmt.Sprintf("Remove %q from %s", ageEntry, secretsPath))
+	}
+	// the basic auth htpasswd and companion service credentials live in
+	// secrets of their own, which only the app file says it has
+	var extraAgePaths, extraEntries, extraSecrets []string
+	if hasNix {
+		config, err := parseAppFile(nixPath)
+		if config == nil {
+			fmt.Println(promptStyle.Render("⚠️ " + err.Error()))
+			fmt.Println(mutedStyle.Render("Its companion and basic auth secrets, if any, are left in place"))
+		} else {
+			for _, s := range config.Services {
+				extraSecrets = append(extraSecrets, companionName(appName, s))
+			}
+			if config.BasicAuth {
+				extraSecrets = append(extraSecrets, basicAuthSecretName(appName))
+			}
+		}
+	}
+	for _, secret := range extraSecrets {
+		if path := filepath.Join(appsDir, secret+".age"); fileExists(path) {
+			extraAgePaths = append(extraAgePaths, path)
+			plan = append(plan, "Delete "+path)
+		}
+		if entry := ageEntryPath(configDir, secret); secrets[entry] {
+			extraEntries = append(extraEntries, entry)
+			plan = append(plan, fmt.Sprintf("Remove %q from %s", entry, secretsPath))
+		}
+	}
+
+	if len(plan) == 0 {
+		fmt.Println(errorStyle.Render("✗ Nothing to remove: no app named " + appName))
+		os.Exit(1)
+	}
+
+	fmt.Println(headerStyle.Render("🗑️ Remove " + appName))
+	for _, step := range plan {
+		fmt.Println("• " + step)
+	}
+
+	if dryRun {
+		fmt.Println(mutedStyle.Render("ℹ️ Dry run - no changes made"))
+		return
+	}
+
+	if !yes && !confirm("Proceed?") {
+		fmt.Println(mutedStyle.Render("ℹ️ Aborted - no changes made"))
+		return
+	}
+
+	tx := newTransaction()
+
+	if hasNix {
+		if err := tx.Step("Deleting "+nixPath, func() error { return tx.Remove(nixPath) }); err != nil {
+			abortTransaction(tx, err)
+		}
+		fmt.Println(successStyle.Render("✓ Deleted " + nixPath))
+	}
+
+	if hasAge {
+		if err := tx.Step("Deleting "+agePath, func() error { return tx.Remove(agePath) }); err != nil {
+			abortTransaction(tx, err)
+		}
+		fmt.Println(successStyle.Render("✓ Deleted " + agePath))
+	}
+
+	for _, path := range extraAgePaths {
+		if err := tx.Step("Deleting "+path, func() error { return tx.Remove(path) }); err != nil {
+			abortTransaction(tx, err)
+		}
+		fmt.Println(successStyle.Render("✓ Deleted " + path))
+	}
+
+	if hasPort {
+		delete(registry.Allocations, appName)
+		if err := tx.Step("Saving port registry", func() error {
+			if err := tx.Track(filepath.Join(configDir, "ports.json")); err != nil {
+				return err
+			}
+			return savePortRegistry(registry, configDir)
+		}); err != nil {
+			abortTransaction(tx, err)
+		}
+		fmt.Println(successStyle.Render(fmt.Sprintf("✓ Released host port %d", port)))
+	}
+
+	if hasSchema {
+		delete(schemas.Apps, appName)
+		if err := tx.Step("Saving env schemas", func() error {
+			if err := tx.Track(filepath.Join(configDir, "env-schema.json")); err != nil {
+				return err
+			}
+			return saveEnvSchemas(schemas, configDir)
+		}); err != nil {
+			abortTransaction(tx, err)
+		}
+		fmt.Println(successStyle.Render("✓ Removed the env schema"))
+	}
+
+	if hasSecretEntry {
+		if err := tx.Step("Updating secrets.nix", func() error {
+			if err := tx.Track(secretsPath); err != nil {
+				return err
+			}
+			return removeSecretsNixEntry(ageEntry, secretsPath)
+		}); err != nil {
+			abortTransaction(tx, err)
+		}
+		fmt.Println(successStyle.Render("✓ Updated " + secretsPath))
+	}
+
+	for _, entry := range extraEntries {
+		if err := tx.Step("Updating secrets.nix", func() error {
+			if err := tx.Track(secretsPath); err != nil {
+				return err
+			}
+			return removeSecretsNixEntry(entry, secretsPath)
+		}); err != nil {
+			abortTransaction(tx, err)
+		}
+		fmt.Println(successStyle.Render("✓ Updated " + secretsPath))
+	}
+
+	tx.Commit()
+	fmt.Println(successStyle.Render("✨ Removed " + appName + ". Run `rollout deploy` to apply."))
+}
+
+// removeSecretsNixEntry drops the publicKeys entry for a secret.
+func removeSecretsNixEntry(ageEntry, secretsPath string) error {
+	secrets, err := loadSecretsNix(secretsPath)
+	if err != nil {
+		return err
+	}
+	if err := secrets.removeEntry(ageEntry); err != nil {
+		return err
+	}
+	return secrets.save()
+}
+
+// confirm asks a yes/no question on stdin, defaulting to no.
+func confirm(question string) bool {
+	fmt.Print(promptStyle.Render(question) + " [y/N] ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
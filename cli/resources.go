@@ -0,0 +1,151 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// restartPolicies are the systemd Restart= values accepted by --restart.
+// oci-containers runs containers with `docker run --rm`, which docker refuses
+// to combine with its own --restart, so the policy goes on the systemd unit
+// that supervises the container instead.
+var restartPolicies = []string{"always", "on-failure", "on-abnormal", "no"}
+
+// hasResources reports whether any resource limit or runtime option is set.
+func (c *NixAppConfig) hasResources() bool {
+	return len(c.resourceOptions()) > 0 || c.Restart != ""
+}
+
+// resourceOptions returns the docker run flags for the resource limits and
+// runtime options.
+func (c *NixAppConfig) resourceOptions() []string {
+	var opts []string
+	if c.Memory != "" {
+		opts = append(opts, "--memory="+c.Memory)
+	}
+	if c.CPUs != "" {
+		opts = append(opts, "--cpus="+c.CPUs)
+	}
+	if c.User != "" {
+		opts = append(opts, "--user="+c.User)
+	}
+	if c.ReadOnly {
+		opts = append(opts, "--read-only")
+	}
+	for _, t := range c.Tmpfs {
+		opts = append(opts, "--tmpfs="+t)
+	}
+	for _, capability := range c.CapDrop {
+		opts = append(opts, "--cap-drop="+capability)
+	}
+	return opts
+}
+
+// readResourceOption fills the resources from one docker flag and reports
+// whether it was one of them.
+func (c *NixAppConfig) readResourceOption(flag, value string) bool {
+	switch flag {
+	case "--memory":
+		c.Memory = value
+	case "--cpus":
+		c.CPUs = value
+	case "--user":
+		c.User = value
+	case "--read-only":
+		c.ReadOnly = true
+	case "--tmpfs":
+		c.Tmpfs = append(c.Tmpfs, value)
+	case "--cap-drop":
+		c.CapDrop = append(c.CapDrop, value)
+	default:
+		return false
+	}
+	return true
+}
+
+// resourceSummary describes the resources for the init summary.
+func (c *NixAppConfig) resourceSummary() string {
+	var parts []string
+	if c.Memory != "" {
+		parts = append(parts, "memory "+c.Memory)
+	}
+	if c.CPUs != "" {
+		parts = append(parts, c.CPUs+" CPUs")
+	}
+	if c.Restart != "" {
+		parts = append(parts, "restart "+c.Restart)
+	}
+	if c.User != "" {
+		parts = append(parts, "user "+c.User)
+	}
+	if c.ReadOnly {
+		parts = append(parts, "read-only")
+	}
+	for _, t := range c.Tmpfs {
+		parts = append(parts, "tmpfs "+t)
+	}
+	if len(c.CapDrop) > 0 {
+		parts = append(parts, "drop "+strings.Join(c.CapDrop, ","))
+	}
+	return strings.Join(parts, ", ")
+}
+
+var (
+	memoryPattern     = regexp.MustCompile(`^[0-9]+[bkmgBKMG]?$`)
+	userPattern       = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*(:[A-Za-z0-9_][A-Za-z0-9_.-]*)?$`)
+	tmpfsPattern      = regexp.MustCompile(`^(/[A-Za-z0-9._-]+)+(:[a-z0-9=,]+)?$`)
+	capabilityPattern = regexp.MustCompile(`^(ALL|[A-Z][A-Z_]*)$`)
+)
+
+func validateMemory(memory string) error {
+	if memory != "" && !memoryPattern.MatchString(memory) {
+		return invalid("memory", memory, "must be a size such as 512m or 2g")
+	}
+	return nil
+}
+
+func validateCPUs(cpus string) error {
+	if cpus == "" {
+		return nil
+	}
+	n, err := strconv.ParseFloat(cpus, 64)
+	if err != nil || n <= 0 {
+		return invalid("cpus", cpus, "must be a positive number such as 0.5 or 2")
+	}
+	return nil
+}
+
+func validateRestart(policy string) error {
+	if policy == "" {
+		return nil
+	}
+	for _, p := range restartPolicies {
+		if policy == p {
+			return nil
+		}
+	}
+	return invalid("restart", policy, "must be one of %s", strings.Join(restartPolicies, ", "))
+}
+
+func validateUser(user string) error {
+	if user != "" && !userPattern.MatchString(user) {
+		return invalid("user", user, "must be a user or uid, optionally with :group (e.g., 1000:1000)")
+	}
+	return nil
+}
+
+// validateTmpfs checks a tmpfs mount of the form /path[:options].
+func validateTmpfs(tmpfs string) error {
+	if !tmpfsPattern.MatchString(tmpfs) {
+		return invalid("tmpfs", tmpfs, "must be an absolute path, optionally followed by :options (e.g., /tmp:size=64m)")
+	}
+	return nil
+}
+
+func validateCapability(capability string) error {
+	if !capabilityPattern.MatchString(capability) {
+		return invalid("cap-drop", capability, "must be ALL or an upper-case capability name (e.g., NET_RAW)")
+	}
+	return nil
+}
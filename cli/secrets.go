@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newSecretsCmd(configDir *string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "secrets",
+		Short: "read and change an app's encrypted environment",
+	}
+	cmd.AddCommand(newSecretsShowCmd(configDir))
+	cmd.AddCommand(newSecretsSetCmd(configDir))
+	cmd.AddCommand(newSecretsUnsetCmd(configDir))
+	cmd.AddCommand(newSecretsEditCmd(configDir))
+	cmd.AddCommand(newSecretsDiffCmd(configDir))
+	cmd.AddCommand(newSecretsRecipientsCmd(configDir))
+	cmd.AddCommand(newSecretsSchemaCmd(configDir))
+	return cmd
+}
+
+func newSecretsShowCmd(configDir *string) *cobra.Command {
+	var reveal bool
+
+	cmd := &cobra.Command{
+		Use:   "show <app>",
+		Short: "list the keys in an app's secret",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			entry := mustFindSecret(*configDir, args[0])
+			_, env := mustDecryptSecretEnv(entry)
+			if len(env) == 0 {
+				fmt.Println(mutedStyle.Render("ℹ️ " + entry + " is empty"))
+				return
+			}
+			for _, key := range sortedEnvKeys(env) {
+				if reveal {
+					fmt.Println(key + "=" + env[key])
+				} else {
+					fmt.Println(key)
+				}
+			}
+		},
+	}
+	cmd.Flags().BoolVar(&reveal, "reveal", false, "print the values as well as the keys")
+
+	return cmd
+}
+
+func newSecretsSetCmd(configDir *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "set <app> KEY=VALUE...",
+		Short: "set variables in an app's secret, creating it if needed",
+		Args:  cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			appName := args[0]
+			mustValidateAppArg(appName)
+			vars := map[string]string{}
+			for _, a := range args[1:] {
+				key, value, err := parseEnvAssignment(a)
+				if err != nil {
+					fmt.Println(errorStyle.Render("✗ " + err.Error()))
+					os.Exit(1)
+				}
+				vars[key] = value
+			}
+
+			lock := mustLockConfigDir(*configDir)
+			defer lock.Unlock()
+
+			entry := ageEntryPath(*configDir, appName)
+			agePath := filepath.Join(*configDir, "apps", appName+".age")
+			schema := mustLoadAppEnvSchema(*configDir, appName)
+			nixPath := filepath.Join(*configDir, "apps", appName+".nix")
+			exists := fileExists(agePath)
+
+			update := func(tx *Transaction) {
+				if !exists {
+					secretsPath := secretsNixPath()
+					if err := tx.Step("Updating secrets.nix", func() error {
+						if err := tx.Track(secretsPath); err != nil {
+							return err
+						}
+						return updateSecretsNix(entry, secretsPath)
+					}); err != nil {
+						abortTransaction(tx, err)
+					}
+				}
+				if err := tx.Step("Encrypting "+entry, func() error {
+					var content []byte
+					if exists {
+						var err error
+						if content, err = decryptSecretData(entry); err != nil {
+							return err
+						}
+					}
+					if err := tx.Track(agePath); err != nil {
+						return err
+					}
+					for _, key := range sortedEnvKeys(vars) {
+						content = setEnvLine(content, key, vars[key])
+					}
+					if err := checkRequiredEnv(schema, content); err != nil {
+						return fmt.Errorf("%s: %w", entry, err)
+					}
+					return encryptSecretData(content, entry)
+				}); err != nil {
+					abortTransaction(tx, err)
+				}
+			}
+
+			switch {
+			case exists:
+				tx := newTransaction()
+				update(tx)
+				tx.Commit()
+			case fileExists(nixPath):
+				// the app has no secret yet, so it has to start reading one
+				current := mustLoadAppForEdit(*configDir, appName)
+				updated := *current
+				updated.HasSecrets = true
+				writeEditedConfig(*configDir, nixPath, current, &updated, false, update)
+			default:
+				fmt.Println(errorStyle.Render("✗ No app or secret named " + appName))
+				os.Exit(1)
+			}
+			fmt.Println(successStyle.Render("✓ Set " + strings.Join(sortedEnvKeys(vars), ", ") + " in " + entry))
+		},
+	}
+}
+
+func newSecretsUnsetCmd(configDir *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "unset <app> KEY...",
+		Short: "remove variables from an app's secret",
+		Args:  cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			lock := mustLockConfigDir(*configDir)
+			defer lock.Unlock()
+
+			entry := mustFindSecret(*configDir, args[0])
+			content, _ := mustDecryptSecretEnv(entry)
+			for _, key := range args[1:] {
+				var removed bool
+				if content, removed = removeEnvLine(content, key); !removed {
+					fmt.Println(errorStyle.Render("✗ " + entry + " has no " + key))
+					os.Exit(1)
+				}
+			}
+			if err := checkRequiredEnv(mustLoadAppEnvSchema(*configDir, args[0]), content); err != nil {
+				fmt.Println(errorStyle.Render("✗ Can't unset: " + err.Error()))
+				os.Exit(1)
+			}
+
+			tx := newTransaction()
+			if err := tx.Step("Encrypting "+entry, func() error {
+				if err := tx.Track(secretFilePath(entry)); err != nil {
+					return err
+				}
+				return encryptSecretData(content, entry)
+			}); err != nil {
+				abortTransaction(tx, err)
+			}
+			tx.Commit()
+			fmt.Println(successStyle.Render("✓ Removed " + strings.Join(args[1:], ", ") + " from " + entry))
+		},
+	}
+}
+
+func newSecretsEditCmd(configDir *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "edit <app>",
+		Short: "open an app's decrypted secret in $EDITOR",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			lock := mustLockConfigDir(*configDir)
+			defer lock.Unlock()
+
+			entry := mustFindSecret(*configDir, args[0])
+			schema := mustLoadAppEnvSchema(*configDir, args[0])
+			tx := newTransaction()
+			if err := tx.Step("Editing "+entry, func() error {
+				if err := tx.Track(secretFilePath(entry)); err != nil {
+					return err
+				}
+				return editSecret(entry, schema)
+			}); err != nil {
+				abortTransaction(tx, err)
+			}
+			tx.Commit()
+		},
+	}
+}
+
+func newSecretsDiffCmd(configDir *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "diff <app> <env-file>",
+		Short: "compare an app's secret with a local env file, without printing values",
+		Long: "Lists the keys the env file would add to, remove from or change in the app's\n" +
+			"secret. Values are never printed. Exits with status 1 if they differ.",
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			entry := mustFindSecret(*configDir, args[0])
+			local, err := readEnvFile(args[1])
+			if err != nil {
+				fmt.Println(errorStyle.Render("✗ Failed to read env file: " + err.Error()))
+				os.Exit(1)
+			}
+			_, secret := mustDecryptSecretEnv(entry)
+
+			all := map[string]string{}
+			for key := range secret {
+				all[key] = ""
+			}
+			for key := range local {
+				all[key] = ""
+			}
+			added, removed, changed := 0, 0, 0
+			for _, key := range sortedEnvKeys(all) {
+				value, inSecret := secret[key]
+				localValue, inLocal := local[key]
+				switch {
+				case !inSecret:
+					added++
+					fmt.Println(successStyle.UnsetBold().Render("+ " + key))
+				case !inLocal:
+					removed++
+					fmt.Println(errorStyle.UnsetBold().Render("- " + key))
+				case value != localValue:
+					changed++
+					fmt.Println(promptStyle.UnsetBold().Render("~ " + key))
+				}
+			}
+
+			if added+removed+changed == 0 {
+				fmt.Println(successStyle.Render("✓ " + args[1] + " matches " + entry))
+				return
+			}
+			fmt.Println(mutedStyle.Render(fmt.Sprintf("%d added, %d removed, %d changed", added, removed, changed)))
+			os.Exit(1)
+		},
+	}
+}
+
+func newSecretsRecipientsCmd(configDir *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "recipients <app> [key...]",
+		Short: "show or set the keys that can read an app's secret",
+		Long: "With only <app>, prints the keys its secret is encrypted to. With keys, which\n" +
+			"are names bound in secrets.nix (see `rollout keys list`), replaces them and\n" +
+			"re-encrypts the secret for the new set.",
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			mustValidateAppArg(args[0])
+			entry := ageEntryPath(*configDir, args[0])
+			names := args[1:]
+
+			if len(names) > 0 {
+				lock := mustLockConfigDir(*configDir)
+				defer lock.Unlock()
+			}
+
+			secrets := mustLoadSecretsNix()
+			b := secrets.entry(entry)
+			if b == nil {
+				fmt.Println(errorStyle.Render("✗ " + args[0] + " has no entry in " + secrets.path))
+				os.Exit(1)
+			}
+			if len(names) == 0 {
+				for _, item := range publicKeysOf(b).Items {
+					fmt.Println(secrets.src[item.Pos:item.End])
+				}
+				return
+			}
+
+			for _, name := range names {
+				if secrets.key(name) == nil {
+					fmt.Println(errorStyle.Render("✗ No key named " + name))
+					fmt.Println(mutedStyle.Render("Add it with `rollout keys add " + name + " <public-key>`"))
+					os.Exit(1)
+				}
+			}
+
+			var plain []byte
+			exists := fileExists(secretFilePath(entry))
+			if exists {
+				var err error
+				if plain, err = decryptSecretData(entry); err != nil {
+					fmt.Println(errorStyle.Render("✗ Failed to decrypt secret: " + err.Error()))
+					os.Exit(1)
+				}
+			}
+
+			tx := newTransaction()
+			if err := tx.Step("Updating secrets.nix", func() error {
+				if err := tx.Track(secrets.path); err != nil {
+					return err
+				}
+				if err := secrets.setRecipients(entry, names); err != nil {
+					return err
+				}
+				return secrets.save()
+			}); err != nil {
+				abortTransaction(tx, err)
+			}
+			if exists {
+				if err := tx.Step("Re-encrypting "+entry, func() error {
+					if err := tx.Track(secretFilePath(entry)); err != nil {
+						return err
+					}
+					return encryptSecretData(plain, entry)
+				}); err != nil {
+					abortTransaction(tx, err)
+				}
+			}
+			tx.Commit()
+			fmt.Println(successStyle.Render("✓ " + entry + " is now encrypted to " + strings.Join(names, ", ")))
+		},
+	}
+}
+
+// mustFindSecret returns the secrets.nix entry of an existing secret, which
+// may be an app's own or one of its companion or basic auth secrets.
+func mustFindSecret(configDir, name string) string {
+	mustValidateAppArg(name)
+	if !fileExists(filepath.Join(configDir, "apps", name+".age")) {
+		fmt.Println(errorStyle.Render("✗ " + name + " has no secret"))
+		fmt.Println(mutedStyle.Render("Create one with `rollout secrets set " + name + " KEY=VALUE`"))
+		os.Exit(1)
+	}
+	return ageEntryPath(configDir, name)
+}
+
+// mustDecryptSecretEnv decrypts a secret and parses it as an env file.
+func mustDecryptSecretEnv(entry string) ([]byte, map[string]string) {
+	content, err := decryptSecretData(entry)
+	if err != nil {
+		fmt.Println(errorStyle.Render("✗ Failed to decrypt secret: " + err.Error()))
+		os.Exit(1)
+	}
+	env, err := parseEnvFile(string(content))
+	if err != nil {
+		fmt.Println(errorStyle.Render("✗ " + entry + " is not an env file: " + err.Error()))
+		os.Exit(1)
+	}
+	return content, env
+}
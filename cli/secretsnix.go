@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// secretsNix is the agenix rules file, parsed so it can be edited in place:
+//
+//	let
+//	  laptop = "ssh-ed25519 ...";
+//	in
+//	{
+//	  "servers/apps/app.age".publicKeys = [ laptop ];
+//	}
+//
+// Every change is spliced into the original text and the file parsed again,
+// so comments and formatting outside the edited bindings are kept as-is.
+type secretsNix struct {
+	path    string
+	src     string
+	let     *nixNode      // the let expression, if the file has one
+	body    *nixNode      // the attrset of secrets
+	keys    []*nixBinding // let bindings naming a key or a list of keys
+	entries []*nixBinding // "<path>".publicKeys bindings
+}
+
+func loadSecretsNix(path string) (*secretsNix, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &secretsNix{path: path, src: string(content)}
+	return s, s.parse()
+}
+
+func (s *secretsNix) parse() error {
+	root, err := parseNix(s.src)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	s.let, s.keys, s.entries = nil, nil, nil
+	if root.Kind == nixLet {
+		s.let = root
+		for _, b := range root.Attrs {
+			if len(b.Path) == 1 {
+				s.keys = append(s.keys, b)
+			}
+		}
+		root = root.Items[0]
+	}
+	if root.Kind != nixAttrs {
+		return fmt.Errorf("%s: expected an attribute set of secrets, found a %s", s.path, root.Kind)
+	}
+	s.body = root
+	for _, b := range root.Attrs {
+		if publicKeysOf(b) != nil {
+			s.entries = append(s.entries, b)
+		}
+	}
+	return nil
+}
+
+// publicKeysOf returns the publicKeys value of an entry, written either as
+// `"path".publicKeys = ...;` or as `"path" = { publicKeys = ...; };`.
+func publicKeysOf(b *nixBinding) *nixNode {
+	switch {
+	case len(b.Path) == 2 && b.Path[1] == "publicKeys":
+		return b.Value
+	case len(b.Path) == 1 && b.Value.Kind == nixAttrs:
+		for _, attr := range b.Value.Attrs {
+			if len(attr.Path) == 1 && attr.Path[0] == "publicKeys" {
+				return attr.Value
+			}
+		}
+	}
+	return nil
+}
+
+func (s *secretsNix) save() error {
+	return atomicWriteFile(s.path, []byte(s.src), 0o644)
+}
+
+func (s *secretsNix) key(name string) *nixBinding {
+	for _, b := range s.keys {
+		if b.Path[0] == name {
+			return b
+		}
+	}
+	return nil
+}
+
+func (s *secretsNix) entry(path string) *nixBinding {
+	for _, b := range s.entries {
+		if b.Path[0] == path {
+			return b
+		}
+	}
+	return nil
+}
+
+// recipients returns the public keys an entry is encrypted to, with names
+// resolved through the let bindings.
+func (s *secretsNix) recipients(path string) ([]string, error) {
+	b := s.entry(path)
+	if b == nil {
+		return nil, fmt.Errorf("%s has no publicKeys for %q", s.path, path)
+	}
+	return s.resolve(publicKeysOf(b), 0)
+}
+
+// resolve flattens a publicKeys value. Bindings may themselves be lists of
+// other bindings.
+func (s *secretsNix) resolve(node *nixNode, depth int) ([]string, error) {
+	if depth > 8 {
+		return nil, fmt.Errorf("line %d: recipient references nest too deeply", node.Line)
+	}
+	switch node.Kind {
+	case nixString:
+		return []string{node.Str}, nil
+	case nixList:
+		var out []string
+		for _, item := range node.Items {
+			resolved, err := s.resolve(item, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, resolved...)
+		}
+		return out, nil
+	case nixSelect:
+		if b := s.key(node.Str); b != nil && len(node.Path) == 1 {
+			return s.resolve(b.Value, depth+1)
+		}
+		return nil, fmt.Errorf("line %d: %s is not a key bound in the let block", node.Line, node.Str)
+	}
+	return nil, fmt.Errorf("line %d: expected a public key, found a %s", node.Line, node.Kind)
+}
+
+// refersTo reports whether a publicKeys list names key directly.
+func refersTo(list *nixNode, key string) bool {
+	for _, item := range list.Items {
+		if item.Kind == nixSelect && item.Str == key {
+			return true
+		}
+	}
+	return false
+}
+
+// setKey binds name to a public key, replacing the key already bound to it or
+// adding a binding after the last one.
+func (s *secretsNix) setKey(name, value string) error {
+	if b := s.key(name); b != nil {
+		return s.splice(b.Value.Pos, b.Value.End, nixQuote(value))
+	}
+	binding := name + " = " + nixQuote(value) + ";"
+	switch {
+	case len(s.keys) > 0:
+		last := s.keys[len(s.keys)-1]
+		end := s.lineEnd(last.End)
+		return s.splice(end, end, "\n"+s.lineIndent(last.Pos)+binding)
+	case s.let != nil:
+		// `let` itself is the first token of the file's expression
+		return s.splice(s.let.Pos+len("let"), s.let.Pos+len("let"), "\n  "+binding)
+	}
+	return s.splice(s.body.Pos, s.body.Pos, "let\n  "+binding+"\nin\n")
+}
+
+// removeKey drops a key's binding. References to it must be removed first.
+func (s *secretsNix) removeKey(name string) error {
+	b := s.key(name)
+	if b == nil {
+		return fmt.Errorf("no key named %s in %s", name, s.path)
+	}
+	return s.removeSpan(b.Pos, b.End)
+}
+
+// addReference appends a name to an entry's publicKeys list, one item per
+// line if the list already is.
+func (s *secretsNix) addReference(path, name string) error {
+	list, err := s.publicKeysList(path)
+	if err != nil {
+		return err
+	}
+	closing := list.End - 1
+	if strings.Contains(s.src[list.Pos:list.End], "\n") {
+		indent := s.lineIndent(closing) + "  "
+		if len(list.Items) > 0 {
+			indent = s.lineIndent(list.Items[len(list.Items)-1].Pos)
+		}
+		lineStart := strings.LastIndex(s.src[:closing], "\n") + 1
+		if strings.TrimSpace(s.src[lineStart:closing]) == "" {
+			return s.splice(lineStart, lineStart, indent+name+"\n")
+		}
+	}
+	inner := strings.TrimRight(s.src[list.Pos:closing], " \t")
+	return s.splice(list.Pos+len(inner), closing, " "+name+" ")
+}
+
+// removeReference drops every item of an entry's publicKeys list that names
+// key or spells out value.
+func (s *secretsNix) removeReference(path, key, value string) error {
+	for {
+		list, err := s.publicKeysList(path)
+		if err != nil {
+			return err
+		}
+		var item *nixNode
+		for _, it := range list.Items {
+			if (it.Kind == nixSelect && it.Str == key) || (it.Kind == nixString && it.Str == value) {
+				item = it
+				break
+			}
+		}
+		if item == nil {
+			return nil
+		}
+		if err := s.removeSpan(item.Pos, item.End); err != nil {
+			return err
+		}
+	}
+}
+
+// publicKeysList looks an entry's list up afresh, since every edit moves the
+// offsets of what follows it.
+func (s *secretsNix) publicKeysList(path string) (*nixNode, error) {
+	b := s.entry(path)
+	if b == nil {
+		return nil, fmt.Errorf("%s has no publicKeys for %q", s.path, path)
+	}
+	list := publicKeysOf(b)
+	if list.Kind != nixList {
+		return nil, fmt.Errorf("line %d: publicKeys of %q is not a list", list.Line, path)
+	}
+	return list, nil
+}
+
+// defaultRecipients is what a new secret is encrypted to: the items of the
+// system secret's list, as written, or else every key bound in the let block.
+func (s *secretsNix) defaultRecipients() []string {
+	for _, b := range s.entries {
+		if !strings.HasSuffix(b.Path[0], "secrets/system.age") {
+			continue
+		}
+		if list := publicKeysOf(b); list.Kind == nixList {
+			items := make([]string, 0, len(list.Items))
+			for _, item := range list.Items {
+				items = append(items, s.src[item.Pos:item.End])
+			}
+			return items
+		}
+	}
+	names := make([]string, 0, len(s.keys))
+	for _, b := range s.keys {
+		names = append(names, b.Path[0])
+	}
+	return names
+}
+
+// addEntry adds a publicKeys entry after the last one, laid out like it.
+// Recipients are Nix expressions, usually let-bound key names.
+func (s *secretsNix) addEntry(path string, recipients []string) error {
+	if s.entry(path) != nil {
+		return fmt.Errorf("%s already has publicKeys for %q", s.path, path)
+	}
+	if n := len(s.entries); n > 0 {
+		last := s.entries[n-1]
+		indent := s.lineIndent(last.Pos)
+		sep := "\n"
+		if n == 1 || strings.Contains(s.src[s.entries[n-2].End:last.Pos], "\n\n") {
+			sep = "\n\n"
+		}
+		pos := s.lineEnd(last.End)
+		return s.splice(pos, pos, sep+indent+nixQuote(path)+".publicKeys = "+s.renderList(recipients, indent)+";")
+	}
+	binding := "  " + nixQuote(path) + ".publicKeys = " + s.renderList(recipients, "  ") + ";\n"
+	closing := s.body.End - 1
+	lineStart := strings.LastIndex(s.src[:closing], "\n") + 1
+	if strings.TrimSpace(s.src[lineStart:closing]) == "" {
+		return s.splice(lineStart, lineStart, binding)
+	}
+	start := len(strings.TrimRight(s.src[:closing], " \t"))
+	return s.splice(start, closing, "\n"+binding)
+}
+
+// setRecipients replaces the publicKeys list of an entry.
+func (s *secretsNix) setRecipients(path string, recipients []string) error {
+	b := s.entry(path)
+	if b == nil {
+		return fmt.Errorf("%s has no publicKeys for %q", s.path, path)
+	}
+	list := publicKeysOf(b)
+	return s.splice(list.Pos, list.End, s.renderList(recipients, s.lineIndent(b.Pos)))
+}
+
+// removeEntry drops an entry, along with the comment lines directly above it
+// and the blank line separating it from the one before.
+func (s *secretsNix) removeEntry(path string) error {
+	b := s.entry(path)
+	if b == nil {
+		return fmt.Errorf("%s has no publicKeys for %q", s.path, path)
+	}
+	start := strings.LastIndex(s.src[:b.Pos], "\n") + 1
+	if strings.TrimSpace(s.src[start:b.Pos]) != "" {
+		return s.removeSpan(b.Pos, b.End)
+	}
+	for start > 0 {
+		prev := strings.LastIndex(s.src[:start-1], "\n") + 1
+		if !strings.HasPrefix(strings.TrimSpace(s.src[prev:start-1]), "#") {
+			break
+		}
+		start = prev
+	}
+	if strings.HasSuffix(s.src[:start], "\n\n") {
+		start--
+	}
+	return s.removeSpan(start, b.End)
+}
+
+// renderList writes a publicKeys list one item per line, indented one level
+// deeper than its binding.
+func (s *secretsNix) renderList(items []string, indent string) string {
+	if len(items) == 0 {
+		return "[ ]"
+	}
+	var b strings.Builder
+	b.WriteString("[\n")
+	for _, item := range items {
+		b.WriteString(indent + "  " + item + "\n")
+	}
+	b.WriteString(indent + "]")
+	return b.String()
+}
+
+// splice replaces src[pos:end] with text and parses the result, so offsets
+// are fresh for the next edit.
+func (s *secretsNix) splice(pos, end int, text string) error {
+	s.src = s.src[:pos] + text + s.src[end:]
+	return s.parse()
+}
+
+// removeSpan cuts src[pos:end], taking the whole line and any comment
+// trailing it when nothing else is on it, or the spaces after it otherwise.
+func (s *secretsNix) removeSpan(pos, end int) error {
+	lineStart := strings.LastIndex(s.src[:pos], "\n") + 1
+	lineEnd := s.lineEnd(end)
+	rest := strings.TrimSpace(s.src[end:lineEnd])
+	if strings.TrimSpace(s.src[lineStart:pos]) == "" && (rest == "" || strings.HasPrefix(rest, "#")) {
+		if lineEnd < len(s.src) {
+			lineEnd++
+		}
+		return s.splice(lineStart, lineEnd, "")
+	}
+	for end < len(s.src) && (s.src[end] == ' ' || s.src[end] == '\t') {
+		end++
+	}
+	return s.splice(pos, end, "")
+}
+
+// lineEnd is the offset of the newline ending the line holding pos.
+func (s *secretsNix) lineEnd(pos int) int {
+	if i := strings.IndexByte(s.src[pos:], '\n'); i >= 0 {
+		return pos + i
+	}
+	return len(s.src)
+}
+
+// lineIndent is the leading whitespace of the line holding pos.
+func (s *secretsNix) lineIndent(pos int) string {
+	lineStart := strings.LastIndex(s.src[:pos], "\n") + 1
+	line := s.src[lineStart:]
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// loadTestSecretsNix copies testdata/secretsnix/secrets.nix somewhere it can
+// be edited and loads it.
+func loadTestSecretsNix(t *testing.T) *secretsNix {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("testdata", "secretsnix", "secrets.nix"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "secrets.nix")
+	writeTestFile(t, path, string(content), 0o644)
+	s, err := loadSecretsNix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// TestSecretsNixEdits applies each edit to testdata/secretsnix/secrets.nix
+// and compares the saved file with testdata/secretsnix/<name>.nix.
+func TestSecretsNixEdits(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(s *secretsNix) error
+	}{
+		{"add-entry", func(s *secretsNix) error {
+			return s.addEntry("servers/apps/web.age", s.defaultRecipients())
+		}},
+		{"remove-entry-with-comment", func(s *secretsNix) error {
+			return s.removeEntry("servers/apps/blog.age")
+		}},
+		{"remove-last-entry", func(s *secretsNix) error {
+			return s.removeEntry("servers/apps/tools.age")
+		}},
+		{"set-recipients", func(s *secretsNix) error {
+			return s.setRecipients("servers/apps/site.age", []string{"laptop", "server"})
+		}},
+		{"set-key", func(s *secretsNix) error {
+			if err := s.setKey("desktop", "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAINewDesktop desktop"); err != nil {
+				return err
+			}
+			return s.setKey("ci", "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAICiKey ci")
+		}},
+		{"add-reference", func(s *secretsNix) error {
+			if err := s.addReference("servers/apps/blog.age", "desktop"); err != nil {
+				return err
+			}
+			return s.addReference("servers/apps/tools.age", "ci")
+		}},
+		{"remove-key", func(s *secretsNix) error {
+			for _, entry := range []string{"servers/secrets/system.age", "servers/apps/blog.age", "servers/apps/tools.age"} {
+				if err := s.removeReference(entry, "server", ""); err != nil {
+					return err
+				}
+			}
+			return s.removeKey("server")
+		}},
+		{"remove-spelled-out-key", func(s *secretsNix) error {
+			if err := s.setRecipients("servers/apps/blog.age", []string{"laptop", nixQuote("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOther other")}); err != nil {
+				return err
+			}
+			return s.removeReference("servers/apps/blog.age", "other", "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOther other")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := loadTestSecretsNix(t)
+			if err := tt.edit(s); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.save(); err != nil {
+				t.Fatal(err)
+			}
+			saved, err := os.ReadFile(s.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, filepath.Join("secretsnix", tt.name+".nix"), string(saved))
+		})
+	}
+}
+
+func TestSecretsNixRemoveThenAdd(t *testing.T) {
+	s := loadTestSecretsNix(t)
+	original := s.src
+	if err := s.removeEntry("servers/apps/tools.age"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.addEntry("servers/apps/tools.age", []string{"laptop"}); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(original, `"servers/apps/tools.age".publicKeys = [ laptop desktop server ]; # shared`,
+		"\"servers/apps/tools.age\".publicKeys = [\n    laptop\n  ];", 1)
+	if s.src != want {
+		t.Errorf("file differs\n got:\n%s\nwant:\n%s", s.src, want)
+	}
+}
+
+func TestSecretsNixRecipients(t *testing.T) {
+	s := loadTestSecretsNix(t)
+	laptop := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAILaptopKey laptop"
+	desktop := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIDesktopKey desktop"
+	server := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIServerKey root@server"
+
+	tests := []struct {
+		entry string
+		want  []string
+	}{
+		{"servers/apps/blog.age", []string{laptop, server}},
+		{"servers/apps/site.age", []string{laptop, desktop}},
+		{"servers/apps/tools.age", []string{laptop, desktop, server}},
+	}
+	for _, tt := range tests {
+		got, err := s.recipients(tt.entry)
+		if err != nil {
+			t.Errorf("recipients(%s): %v", tt.entry, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("recipients(%s) = %q, want %q", tt.entry, got, tt.want)
+		}
+	}
+
+	if got, want := s.defaultRecipients(), []string{"laptop", "server"}; !slices.Equal(got, want) {
+		t.Errorf("defaultRecipients = %q, want the system secret's %q", got, want)
+	}
+}
+
+func TestSecretsNixEditErrors(t *testing.T) {
+	s := loadTestSecretsNix(t)
+	original := s.src
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"add existing", s.addEntry("servers/apps/blog.age", []string{"laptop"}), "already has publicKeys"},
+		{"remove missing", s.removeEntry("servers/apps/gone.age"), "has no publicKeys"},
+		{"recipients of missing", s.setRecipients("servers/apps/gone.age", nil), "has no publicKeys"},
+		{"reference in a non-list", s.addReference("servers/apps/site.age", "ci"), "is not a list"},
+		{"remove unknown key", s.removeKey("ci"), "no key named ci"},
+	}
+	for _, tt := range tests {
+		if tt.err == nil || !strings.Contains(tt.err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want one containing %q", tt.name, tt.err, tt.want)
+		}
+	}
+	if s.src != original {
+		t.Errorf("failed edits changed the file:\n%s", s.src)
+	}
+}
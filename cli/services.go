@@ -0,0 +1,332 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// companionService is a backing service that can be attached to an app. It
+// runs as its own container on a private network shared only with the app,
+// keeps its data in a named volume and is never exposed through traefik.
+type companionService struct {
+	Image    string
+	DataDir  string // mount target of the named volume
+	URLKey   string // variable the app gets the connection URL in
+	Password string // variable the service reads its password from
+	Env      func(app string) map[string]string
+	Cmd      []string // overrides the image's command, if set
+	URL      func(app, password string) string
+}
+
+// companionServices are the services `--with` and `add-service` accept, in
+// the order they are generated.
+var (
+	companionServiceNames = []string{"postgres", "redis"}
+	companionServices     = map[string]companionService{
+		"postgres": {
+			Image:    "postgres:16-alpine",
+			DataDir:  "/var/lib/postgresql/data",
+			URLKey:   "DATABASE_URL",
+			Password: "POSTGRES_PASSWORD",
+			Env: func(app string) map[string]string {
+				return map[string]string{"POSTGRES_USER": app, "POSTGRES_DB": app}
+			},
+			URL: func(app, password string) string {
+				return fmt.Sprintf("postgres://%s:%s@%s:5432/%s", app, password, companionName(app, "postgres"), app)
+			},
+		},
+		"redis": {
+			Image:    "redis:7-alpine",
+			DataDir:  "/data",
+			URLKey:   "REDIS_URL",
+			Password: "REDIS_PASSWORD",
+			// the official image has no password variable, so pass it through
+			// the shell from the environment file
+			Cmd: []string{"sh", "-c", `exec redis-server --appendonly yes --requirepass "$REDIS_PASSWORD"`},
+			URL: func(app, password string) string {
+				return fmt.Sprintf("redis://:%s@%s:6379/0", password, companionName(app, "redis"))
+			},
+		},
+	}
+)
+
+// companionName is the container, and agenix secret, of an app's service.
+func companionName(app, service string) string {
+	return app + "-" + service
+}
+
+// privateNetwork is the docker network an app shares with its services.
+func privateNetwork(app string) string {
+	return app + "-private"
+}
+
+// networkUnit is the systemd unit that creates the private network.
+func networkUnit(app string) string {
+	return "docker-network-" + privateNetwork(app)
+}
+
+// containerNetworks lists the networks the app container joins: traefik's,
+// unless the app is internal, then the private and the named ones.
+func (c *NixAppConfig) containerNetworks() []string {
+	var networks []string
+	if !c.Internal {
+		networks = append(networks, c.Network)
+	}
+	if len(c.Services) > 0 {
+		networks = append(networks, privateNetwork(c.Name))
+	}
+	return append(networks, c.JoinNetworks...)
+}
+
+// dependsOn lists the containers the app container starts after.
+func (c *NixAppConfig) dependsOn() []string {
+	var names []string
+	for _, s := range c.Services {
+		names = append(names, companionName(c.Name, s))
+	}
+	return names
+}
+
+// setCompanions adds the service containers, their secrets and the unit
+// creating the private network to root.
+func (c *NixAppConfig) setCompanions(root *nixNode) {
+	if len(c.Services) == 0 {
+		return
+	}
+	network := privateNetwork(c.Name)
+
+	units := []string{"docker-" + c.Name + ".service"}
+	for i, s := range c.Services {
+		svc := companionServices[s]
+		name := companionName(c.Name, s)
+		units = append(units, "docker-"+name+".service")
+
+		container := nixAttrSet()
+		container.set(nixStr(svc.Image), "image")
+		container.set(nixStrList(network), "networks")
+		container.set(nixStrList(name+"-data:"+svc.DataDir), "volumes")
+		if svc.Env != nil {
+			env := nixAttrSet()
+			vars := svc.Env(c.Name)
+			for _, key := range sortedEnvKeys(vars) {
+				env.set(nixStr(vars[key]), key)
+			}
+			container.set(env, "environment")
+		}
+		container.set(nixListOf(nixRef("config", "age", "secrets", nixQuote(name), "path")), "environmentFiles")
+		if len(svc.Cmd) > 0 {
+			container.set(nixStrList(svc.Cmd...), "cmd")
+		}
+		b := root.set(container, "virtualisation", "oci-containers", "containers", nixQuote(name)).spaced()
+		if i == 0 {
+			b.withComment("Companion services, reachable only from " + c.Name + " on " + network)
+		}
+		root.set(nixPathLit("./"+name+".age"), "age", "secrets", nixQuote(name), "file")
+	}
+
+	docker := nixRef("pkgs", "docker")
+	unit := nixAttrSet()
+	unit.set(nixStr("Create the "+network+" docker network"), "description")
+	unit.set(nixStrList(units...), "requiredBy")
+	unit.set(nixStrList(units...), "before")
+	unit.set(nixStr("oneshot"), "serviceConfig", "Type")
+	unit.set(nixRef("true"), "serviceConfig", "RemainAfterExit")
+	unit.set(nixInterp(docker, nixStr("/bin/docker network inspect "+network+" >/dev/null 2>&1 || "),
+		docker, nixStr("/bin/docker network create --internal "+network)), "script")
+	root.set(unit, "systemd", "services", nixQuote(networkUnit(c.Name))).spaced()
+}
+
+// isCompanionPath reports whether a leaf of an app file belongs to the
+// companion services generated by setCompanions.
+func isCompanionPath(app string, path []string) bool {
+	if len(path) >= 3 && hasPathPrefix(path, "systemd", "services", networkUnit(app)) {
+		return true
+	}
+	for _, s := range companionServiceNames {
+		name := companionName(app, s)
+		if len(path) >= 4 && (hasPathPrefix(path, "virtualisation", "oci-containers", "containers", name) ||
+			hasPathPrefix(path, "age", "secrets", name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// auxiliaryLeaves renders setCompanions and setJoinedNetworks as flattened
+// leaves keyed by their attribute path, to compare a parsed file against.
+func (c *NixAppConfig) auxiliaryLeaves() map[string]string {
+	root := nixAttrSet()
+	c.setCompanions(root)
+	c.setJoinedNetworks(root)
+	var leaves []nixLeaf
+	flattenNixAttrs(nil, root, &leaves)
+
+	out := map[string]string{}
+	for _, leaf := range leaves {
+		out[nixLeafKey(leaf.Path)] = nixValueText(leaf.Value)
+	}
+	return out
+}
+
+func validateService(service string) error {
+	if _, ok := companionServices[service]; !ok {
+		return invalid("service", service, "must be one of %s", strings.Join(companionServiceNames, ", "))
+	}
+	return nil
+}
+
+// sortServices orders services the way they are generated and drops
+// duplicates.
+func sortServices(services []string) []string {
+	var out []string
+	for _, name := range companionServiceNames {
+		for _, s := range services {
+			if s == name {
+				out = append(out, name)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// generatePassword returns a random password that is safe to put in a URL.
+func generatePassword() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// addCompanionSecrets generates credentials for services, encrypts them into
+// each service's own secret and merges the connection URLs into the app's
+// secret, as part of tx.
+func addCompanionSecrets(tx *Transaction, configDir, appName string, services []string) {
+	secretsPath := secretsNixPath()
+	urls := map[string]string{}
+
+	for _, s := range services {
+		svc := companionServices[s]
+		name := companionName(appName, s)
+		password, err := generatePassword()
+		if err != nil {
+			abortTransaction(tx, err)
+		}
+		urls[svc.URLKey] = svc.URL(appName, password)
+
+		entry := ageEntryPath(configDir, name)
+		if err := tx.Step("Updating secrets.nix", func() error {
+			if err := tx.Track(secretsPath); err != nil {
+				return err
+			}
+			return updateSecretsNix(entry, secretsPath)
+		}); err != nil {
+			abortTransaction(tx, err)
+		}
+		agePath := filepath.Join(configDir, "apps", name+".age")
+		if err := tx.Step("Encrypting "+s+" credentials", func() error {
+			if err := tx.Track(agePath); err != nil {
+				return err
+			}
+			return encryptSecretData([]byte(svc.Password+"="+password+"\n"), entry)
+		}); err != nil {
+			abortTransaction(tx, err)
+		}
+	}
+
+	entry := ageEntryPath(configDir, appName)
+	if err := tx.Step("Updating secrets.nix", func() error {
+		if err := tx.Track(secretsPath); err != nil {
+			return err
+		}
+		return updateSecretsNix(entry, secretsPath)
+	}); err != nil {
+		abortTransaction(tx, err)
+	}
+	agePath := filepath.Join(configDir, "apps", appName+".age")
+	if err := tx.Step("Adding "+strings.Join(sortedEnvKeys(urls), ", ")+" to the app secret", func() error {
+		var content []byte
+		if fileExists(agePath) {
+			var err error
+			if content, err = decryptSecretData(entry); err != nil {
+				return err
+			}
+		}
+		if err := tx.Track(agePath); err != nil {
+			return err
+		}
+		for _, key := range sortedEnvKeys(urls) {
+			content = setEnvLine(content, key, urls[key])
+		}
+		return encryptSecretData(content, entry)
+	}); err != nil {
+		abortTransaction(tx, err)
+	}
+}
+
+// mustHaveNoCompanionConflicts exits if a companion of app would take the
+// name of an existing app.
+func mustHaveNoCompanionConflicts(configDir, app string, services []string) {
+	for _, s := range services {
+		name := companionName(app, s)
+		if fileExists(filepath.Join(configDir, "apps", name+".nix")) {
+			fmt.Println(errorStyle.Render("✗ The " + s + " companion of " + app + " would be named " + name + ", which is already an app"))
+			os.Exit(1)
+		}
+	}
+}
+
+func newAddServiceCmd(configDir *string) *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "add-service <app> <" + strings.Join(companionServiceNames, "|") + ">",
+		Short: "attach a database or cache container to an app",
+		Long: "Adds an internal-only companion container on a private network shared with the\n" +
+			"app, with a named volume for its data. Credentials are generated and the\n" +
+			"connection URL is added to the app's agenix secret.",
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			appName, service := args[0], args[1]
+			mustValidateAppArg(appName)
+			if err := validateService(service); err != nil {
+				fmt.Println(errorStyle.Render("✗ " + err.Error()))
+				os.Exit(1)
+			}
+			if !dryRun {
+				lock := mustLockConfigDir(*configDir)
+				defer lock.Unlock()
+			}
+
+			current := mustLoadAppForEdit(*configDir, appName)
+			for _, s := range current.Services {
+				if s == service {
+					fmt.Println(errorStyle.Render("✗ " + appName + " already has " + service))
+					os.Exit(1)
+				}
+			}
+
+			mustHaveNoCompanionConflicts(*configDir, appName, []string{service})
+
+			updated := *current
+			updated.Services = sortServices(append(append([]string{}, current.Services...), service))
+			updated.HasSecrets = true
+
+			filePath := filepath.Join(*configDir, "apps", appName+".nix")
+			writeEditedConfig(*configDir, filePath, current, &updated, dryRun, func(tx *Transaction) {
+				addCompanionSecrets(tx, *configDir, appName, []string{service})
+				fmt.Println(successStyle.Render("✓ " + companionServices[service].URLKey + " added to " + appName + "'s secret"))
+			})
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the diff but don't write it to disk")
+
+	return cmd
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// settingsFileName is the per-repo config file, found by walking up from the
+// working directory.
+const settingsFileName = ".rollout.yaml"
+
+// Settings holds the per-repo defaults. Values come from, in increasing
+// priority: built-in defaults, .rollout.yaml, ROLLOUT_* env vars and flags.
+type Settings struct {
+	ConfigDir    string    `yaml:"config_dir"`
+	RepoRoot     string    `yaml:"repo_root"`
+	Domain       string    `yaml:"domain"`
+	Network      string    `yaml:"network"`
+	CertResolver string    `yaml:"certresolver"`
+	EntryPoints  []string  `yaml:"entrypoints"`
+	PortRange    PortRange `yaml:"port_range"`
+	DispatchRepo string    `yaml:"dispatch_repo"`
+	Identity     string    `yaml:"identity"`
+
+	// File is the .rollout.yaml the settings were read from, if any.
+	File string `yaml:"-"`
+	// repoRootSet is false while RepoRoot is still the built-in default.
+	repoRootSet bool
+}
+
+type PortRange struct {
+	Start int `yaml:"start"`
+	End   int `yaml:"end"`
+}
+
+// settings is resolved once at startup, before flags are defined, so flag
+// defaults reflect the repo's config.
+var settings = defaultSettings()
+
+func defaultSettings() Settings {
+	repoRoot := filepath.Join(os.Getenv("HOME"), "repos", "rollouts")
+	return Settings{
+		ConfigDir:    filepath.Join(repoRoot, "servers"),
+		RepoRoot:     repoRoot,
+		Network:      "web",
+		CertResolver: "letsencrypt",
+		EntryPoints:  []string{"websecure"},
+		PortRange:    PortRange{Start: 10000, End: 19999},
+		DispatchRepo: "Kabilan108/rollouts",
+	}
+}
+
+// loadSettings resolves defaults, the settings file and env vars. The file is
+// $ROLLOUT_CONFIG if set, otherwise the nearest .rollout.yaml above the
+// working directory.
+func loadSettings() (Settings, error) {
+	s := defaultSettings()
+
+	path := os.Getenv("ROLLOUT_CONFIG")
+	if path == "" {
+		if wd, err := os.Getwd(); err == nil {
+			path = findSettingsFile(wd)
+		}
+	}
+
+	if path != "" {
+		if err := s.loadFile(path); err != nil {
+			return s, err
+		}
+	}
+
+	if err := s.loadEnv(); err != nil {
+		return s, err
+	}
+
+	return s, s.validate()
+}
+
+// findSettingsFile walks up from dir looking for .rollout.yaml.
+func findSettingsFile(dir string) string {
+	for {
+		candidate := filepath.Join(dir, settingsFileName)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+func (s *Settings) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var file Settings
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	base := filepath.Dir(abs)
+	s.File = abs
+
+	// the file lives at the repo root unless it says otherwise, and relative
+	// paths are relative to the file
+	s.RepoRoot = base
+	s.repoRootSet = true
+	if file.RepoRoot != "" {
+		s.RepoRoot = resolvePath(base, file.RepoRoot)
+	}
+	s.ConfigDir = filepath.Join(s.RepoRoot, "servers")
+	if file.ConfigDir != "" {
+		s.ConfigDir = resolvePath(base, file.ConfigDir)
+	}
+
+	if file.Domain != "" {
+		s.Domain = file.Domain
+	}
+	if file.Network != "" {
+		s.Network = file.Network
+	}
+	if file.CertResolver != "" {
+		s.CertResolver = file.CertResolver
+	}
+	if len(file.EntryPoints) > 0 {
+		s.EntryPoints = file.EntryPoints
+	}
+	if file.PortRange.Start != 0 {
+		s.PortRange.Start = file.PortRange.Start
+	}
+	if file.PortRange.End != 0 {
+		s.PortRange.End = file.PortRange.End
+	}
+	if file.DispatchRepo != "" {
+		s.DispatchRepo = file.DispatchRepo
+	}
+	if file.Identity != "" {
+		s.Identity = resolvePath(base, file.Identity)
+	}
+
+	return nil
+}
+
+func (s *Settings) loadEnv() error {
+	if v := os.Getenv("ROLLOUT_REPO_ROOT"); v != "" {
+		s.RepoRoot = v
+		s.repoRootSet = true
+		s.ConfigDir = filepath.Join(v, "servers")
+	}
+	if v := os.Getenv("ROLLOUT_CONFIG_DIR"); v != "" {
+		s.ConfigDir = v
+		if os.Getenv("ROLLOUT_REPO_ROOT") == "" {
+			s.RepoRoot = filepath.Dir(filepath.Clean(v))
+			s.repoRootSet = true
+		}
+	}
+	if v := os.Getenv("ROLLOUT_DOMAIN"); v != "" {
+		s.Domain = v
+	}
+	if v := os.Getenv("ROLLOUT_NETWORK"); v != "" {
+		s.Network = v
+	}
+	if v := os.Getenv("ROLLOUT_CERTRESOLVER"); v != "" {
+		s.CertResolver = v
+	}
+	if v := os.Getenv("ROLLOUT_ENTRYPOINTS"); v != "" {
+		s.EntryPoints = splitList(v)
+	}
+	if v := os.Getenv("ROLLOUT_PORT_RANGE"); v != "" {
+		start, end, ok := strings.Cut(v, "-")
+		startPort, err1 := strconv.Atoi(strings.TrimSpace(start))
+		endPort, err2 := strconv.Atoi(strings.TrimSpace(end))
+		if !ok || err1 != nil || err2 != nil {
+			return fmt.Errorf("ROLLOUT_PORT_RANGE must be <start>-<end>, got %q", v)
+		}
+		s.PortRange = PortRange{Start: startPort, End: endPort}
+	}
+	if v := os.Getenv("ROLLOUT_DISPATCH_REPO"); v != "" {
+		s.DispatchRepo = v
+	}
+	if v := os.Getenv("ROLLOUT_IDENTITY"); v != "" {
+		s.Identity = v
+	}
+	return nil
+}
+
+func (s *Settings) validate() error {
+	where := "settings"
+	if s.File != "" {
+		where = s.File
+	}
+	if s.PortRange.Start < 1 || s.PortRange.End > 65535 || s.PortRange.Start > s.PortRange.End {
+		return fmt.Errorf("%s: port_range %d-%d is not a valid range", where, s.PortRange.Start, s.PortRange.End)
+	}
+	if len(s.EntryPoints) == 0 {
+		return fmt.Errorf("%s: entrypoints must not be empty", where)
+	}
+	if s.CertResolver == "" {
+		return fmt.Errorf("%s: certresolver must not be empty", where)
+	}
+	return nil
+}
+
+// findGitRoot walks up from the working directory to the checkout holding it,
+// if that looks like the rollouts repo. Without a configured repo root, deploy
+// pushes that checkout rather than the default path.
+func findGitRoot() (string, bool) {
+	wd, err := os.Getwd()
+	if err != nil || !strings.Contains(wd, "rollouts") {
+		return "", false
+	}
+	for dir := wd; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir, true
+		}
+		if filepath.Dir(dir) == dir {
+			return "", false
+		}
+	}
+}
+
+// secretsNixPath is the agenix rules file at the repo root.
+func secretsNixPath() string {
+	return filepath.Join(settings.RepoRoot, "secrets.nix")
+}
+
+// ageEntryPath is the repo-relative path of an app's secret, as used for its
+// key in secrets.nix and by agenix.
+func ageEntryPath(configDir, appName string) string {
+	path := filepath.Join(configDir, "apps", fmt.Sprintf("%s.age", appName))
+	if rel, err := filepath.Rel(settings.RepoRoot, path); err == nil && !strings.HasPrefix(rel, "..") {
+		path = rel
+	}
+	return filepath.ToSlash(path)
+}
+
+func resolvePath(base, path string) string {
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[2:])
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(base, path)
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,67 @@
+{ config, pkgs, ... }:
+{
+  virtualisation.oci-containers.containers."blog" = rec {
+    image = "kabilan108/blog:1.0";
+    ports = [ "127.0.0.1:8003:4000" ];
+    networks = [ "traefik" "blog-private" "shared" ];
+    dependsOn = [ "blog-postgres" "blog-redis" ];
+    labels = {
+      "traefik.enable" = "true";
+      "traefik.docker.network" = "traefik";
+      "traefik.http.services.blog.loadbalancer.server.port" = "4000";
+
+      # domain router
+      "traefik.http.routers.blog.rule" = "Host(`blog.example.com`) || Host(`www.blog.example.com`)";
+      "traefik.http.routers.blog.entrypoints" = "websecure";
+      "traefik.http.routers.blog.tls.certresolver" = "letsencrypt";
+    };
+    environmentFiles = [ config.age.secrets."blog".path ];
+  };
+
+  # Force image pull on every deployment
+  systemd.services."docker-blog".serviceConfig.ExecStartPre = [
+    "${pkgs.docker}/bin/docker pull kabilan108/blog:1.0"
+  ];
+  age.secrets."blog".file = ./blog.age;
+
+  # Companion services, reachable only from blog on blog-private
+  virtualisation.oci-containers.containers."blog-postgres" = {
+    image = "postgres:16-alpine";
+    networks = [ "blog-private" ];
+    volumes = [ "blog-postgres-data:/var/lib/postgresql/data" ];
+    environment = {
+      POSTGRES_DB = "blog";
+      POSTGRES_USER = "blog";
+    };
+    environmentFiles = [ config.age.secrets."blog-postgres".path ];
+  };
+  age.secrets."blog-postgres".file = ./blog-postgres.age;
+
+  virtualisation.oci-containers.containers."blog-redis" = {
+    image = "redis:7-alpine";
+    networks = [ "blog-private" ];
+    volumes = [ "blog-redis-data:/data" ];
+    environmentFiles = [ config.age.secrets."blog-redis".path ];
+    cmd = [ "sh" "-c" "exec redis-server --appendonly yes --requirepass \"$REDIS_PASSWORD\"" ];
+  };
+  age.secrets."blog-redis".file = ./blog-redis.age;
+
+  systemd.services."docker-network-blog-private" = {
+    description = "Create the blog-private docker network";
+    requiredBy = [ "docker-blog.service" "docker-blog-postgres.service" "docker-blog-redis.service" ];
+    before = [ "docker-blog.service" "docker-blog-postgres.service" "docker-blog-redis.service" ];
+    serviceConfig.Type = "oneshot";
+    serviceConfig.RemainAfterExit = true;
+    script = "${pkgs.docker}/bin/docker network inspect blog-private >/dev/null 2>&1 || ${pkgs.docker}/bin/docker network create --internal blog-private";
+  };
+
+  # Shared networks
+  systemd.services."docker-network-join-shared" = {
+    description = "Create the shared docker network";
+    requiredBy = [ "docker-blog.service" ];
+    before = [ "docker-blog.service" ];
+    serviceConfig.Type = "oneshot";
+    serviceConfig.RemainAfterExit = true;
+    serviceConfig.ExecStart = "-${pkgs.docker}/bin/docker network create shared";
+  };
+}
\ No newline at end of file
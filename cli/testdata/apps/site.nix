@@ -0,0 +1,23 @@
+{ config, pkgs, ... }:
+{
+  virtualisation.oci-containers.containers."site" = rec {
+    image = "kabilan108/site:latest";
+    ports = [ "127.0.0.1:8001:3000" ];
+    networks = [ "traefik" ];
+    labels = {
+      "traefik.enable" = "true";
+      "traefik.docker.network" = "traefik";
+      "traefik.http.services.site.loadbalancer.server.port" = "3000";
+
+      # domain router
+      "traefik.http.routers.site.rule" = "Host(`example.com`) || Host(`www.example.com`)";
+      "traefik.http.routers.site.entrypoints" = "websecure";
+      "traefik.http.routers.site.tls.certresolver" = "letsencrypt";
+    };
+  };
+
+  # Force image pull on every deployment
+  systemd.services."docker-site".serviceConfig.ExecStartPre = [
+    "${pkgs.docker}/bin/docker pull kabilan108/site:latest"
+  ];
+}
\ No newline at end of file
@@ -0,0 +1,60 @@
+{ config, pkgs, ... }:
+{
+  virtualisation.oci-containers.containers."tools" = rec {
+    image = "ghcr.io/kabilan108/tools:main@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef";
+    ports = [ "127.0.0.1:8002:8080" ];
+    networks = [ "traefik" ];
+    volumes = [ "/srv/tools:/data:rw" "cache:/cache:ro" ];
+    extraOptions = [
+      "--memory=512m"
+      "--cpus=1.5"
+      "--user=1000:1000"
+      "--read-only"
+      "--tmpfs=/tmp"
+      "--cap-drop=ALL"
+      "--health-cmd=curl -f http://localhost:8080/healthz"
+      "--health-interval=10s"
+      "--health-timeout=3s"
+      "--health-retries=5"
+    ];
+    labels = {
+      "traefik.enable" = "true";
+      "traefik.docker.network" = "traefik";
+      "traefik.http.services.tools.loadbalancer.server.port" = "8080";
+      "traefik.http.services.tools.loadbalancer.healthcheck.path" = "/healthz";
+      "traefik.http.services.tools.loadbalancer.healthcheck.interval" = "10s";
+      "traefik.http.services.tools.loadbalancer.healthcheck.timeout" = "3s";
+
+      # domain router
+      "traefik.http.routers.tools.rule" = "(Host(`tools.example.com`) || Host(`tools.example.org`)) && PathPrefix(`/api`)";
+      "traefik.http.routers.tools.entrypoints" = "websecure";
+      "traefik.http.routers.tools.tls.certresolver" = "letsencrypt";
+      "traefik.http.routers.tools.priority" = "1004";
+      "traefik.http.routers.tools.middlewares" = "tools-allowip,tools-ratelimit,tools-auth,tools-headers,tools-compress,tools-stripprefix";
+
+      # middlewares
+      "traefik.http.middlewares.tools-allowip.ipallowlist.sourcerange" = "10.0.0.0/8";
+      "traefik.http.middlewares.tools-ratelimit.ratelimit.average" = "100";
+      "traefik.http.middlewares.tools-ratelimit.ratelimit.period" = "1s";
+      "traefik.http.middlewares.tools-ratelimit.ratelimit.burst" = "100";
+      "traefik.http.middlewares.tools-auth.basicauth.usersfile" = "${config.age.secrets."tools-basic-auth".path}";
+      "traefik.http.middlewares.tools-headers.headers.stsseconds" = "31536000";
+      "traefik.http.middlewares.tools-headers.headers.stsincludesubdomains" = "true";
+      "traefik.http.middlewares.tools-headers.headers.contenttypenosniff" = "true";
+      "traefik.http.middlewares.tools-headers.headers.browserxssfilter" = "true";
+      "traefik.http.middlewares.tools-headers.headers.framedeny" = "true";
+      "traefik.http.middlewares.tools-headers.headers.referrerpolicy" = "strict-origin-when-cross-origin";
+      "traefik.http.middlewares.tools-compress.compress" = "true";
+      "traefik.http.middlewares.tools-stripprefix.stripprefix.prefixes" = "/api";
+    };
+    environment = {
+      GREETING = "say \"hi\", \${USER}";
+      LOG_LEVEL = "info";
+    };
+    environmentFiles = [ config.age.secrets."tools".path ];
+  };
+  systemd.services."docker-tools".serviceConfig.Restart = pkgs.lib.mkForce "always";
+  age.secrets."tools".file = ./tools.age;
+  age.secrets."tools-basic-auth".file = ./tools-basic-auth.age;
+  age.secrets."tools-basic-auth".owner = "traefik";
+}
\ No newline at end of file
@@ -0,0 +1,24 @@
+{ config, pkgs, ... }:
+{
+  virtualisation.oci-containers.containers."worker" = rec {
+    image = "kabilan108/worker:latest";
+    networks = [ "shared" ];
+    environmentFiles = [ config.age.secrets."worker".path ];
+  };
+
+  # Force image pull on every deployment
+  systemd.services."docker-worker".serviceConfig.ExecStartPre = [
+    "${pkgs.docker}/bin/docker pull kabilan108/worker:latest"
+  ];
+  age.secrets."worker".file = ./worker.age;
+
+  # Shared networks
+  systemd.services."docker-network-join-shared" = {
+    description = "Create the shared docker network";
+    requiredBy = [ "docker-worker.service" ];
+    before = [ "docker-worker.service" ];
+    serviceConfig.Type = "oneshot";
+    serviceConfig.RemainAfterExit = true;
+    serviceConfig.ExecStart = "-${pkgs.docker}/bin/docker network create shared";
+  };
+}
\ No newline at end of file
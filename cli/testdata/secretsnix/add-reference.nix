@@ -0,0 +1,25 @@
+let
+  # people
+  laptop = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAILaptopKey laptop";
+  desktop = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIDesktopKey desktop";
+  # machines
+  server = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIServerKey root@server";
+  admins = [ laptop desktop ];
+in
+{
+  "servers/secrets/system.age".publicKeys = [
+    laptop
+    server
+  ];
+
+  # the blog's database password
+  "servers/apps/blog.age".publicKeys = [
+    laptop
+    server
+    desktop
+  ];
+
+  "servers/apps/site.age".publicKeys = admins;
+
+  "servers/apps/tools.age".publicKeys = [ laptop desktop server ci ]; # shared
+}
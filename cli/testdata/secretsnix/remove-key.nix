@@ -0,0 +1,21 @@
+let
+  # people
+  laptop = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAILaptopKey laptop";
+  desktop = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIDesktopKey desktop";
+  # machines
+  admins = [ laptop desktop ];
+in
+{
+  "servers/secrets/system.age".publicKeys = [
+    laptop
+  ];
+
+  # the blog's database password
+  "servers/apps/blog.age".publicKeys = [
+    laptop
+  ];
+
+  "servers/apps/site.age".publicKeys = admins;
+
+  "servers/apps/tools.age".publicKeys = [ laptop desktop ]; # shared
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 	"unicode/utf8"
 
+	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -16,7 +17,16 @@ import (
 // Returns the completed AppConfig, a boolean indicating whether the user
 // finished (true) or canceled (false), and an error if one occurred.
 func RunTUI(initial AppConfig) (AppConfig, bool, error) {
-	m := newTUIModel(initial)
+	return runTUIModel(newTUIModel(initial))
+}
+
+// RunEditTUI is like RunTUI but for an existing app: the name and secrets are
+// left alone, and every step starts pre-filled with the current value.
+func RunEditTUI(current AppConfig) (AppConfig, bool, error) {
+	return runTUIModel(newEditTUIModel(current))
+}
+
+func runTUIModel(m tuiModel) (AppConfig, bool, error) {
 	p := tea.NewProgram(m)
 	res, err := p.Run()
 	if err != nil {
@@ -38,16 +48,22 @@ const (
 	fieldSecretMode tuiField = "secret_mode"
 	fieldEnvFile    tuiField = "env_file"
 	fieldMounts     tuiField = "mounts"
+	fieldEnvVars    tuiField = "env_vars"
+	fieldHealthPath tuiField = "health_path"
+	fieldHealthCmd  tuiField = "health_cmd"
 )
 
 type tuiModel struct {
 	input      textinput.Model
+	area       textarea.Model // for the steps taking one entry per line
 	config     AppConfig
 	fields     []tuiField
 	current    int
 	finished   bool
 	err        string
 	secretMode string // none | file | edit
+	title      string
+	prefill    bool // start each step with the current value
 }
 
 func newTUIModel(initial AppConfig) tuiModel {
@@ -61,6 +77,9 @@ func newTUIModel(initial AppConfig) tuiModel {
 		fieldSecretMode,
 		fieldEnvFile, // only used when secretMode == file (otherwise skipped)
 		fieldMounts,
+		fieldEnvVars,
+		fieldHealthPath,
+		fieldHealthCmd,
 	}
 
 	ti := textinput.New()
@@ -69,17 +88,91 @@ func newTUIModel(initial AppConfig) tuiModel {
 	ti.CharLimit = 200
 	ti.SetValue("")
 
+	ta := textarea.New()
+	ta.Prompt = ""
+	ta.ShowLineNumbers = false
+	ta.SetWidth(60)
+	ta.SetHeight(5)
+
 	// establish defaults for optional fields
 	if initial.Network == "" {
-		initial.Network = "web"
+		initial.Network = settings.Network
 	}
 
 	return tuiModel{
 		input:   ti,
+		area:    ta,
 		config:  initial,
 		fields:  fields,
 		current: 0,
+		title:   "Rollout Init",
+	}
+}
+
+func newEditTUIModel(current AppConfig) tuiModel {
+	m := newTUIModel(current)
+	m.fields = []tuiField{
+		fieldImage,
+		fieldDomain,
+		fieldSubdomain,
+		fieldPort,
+		fieldNetwork,
+		fieldMounts,
+		fieldEnvVars,
+		fieldHealthPath,
+		fieldHealthCmd,
+	}
+	if current.Internal {
+		// internal apps have no router, so only the container is editable
+		m.fields = []tuiField{fieldImage, fieldMounts, fieldEnvVars, fieldHealthCmd}
+	}
+	m.title = "Rollout Edit: " + current.Name
+	m.prefill = true
+	m.input.SetValue(m.currentValue())
+	return m
+}
+
+// currentValue renders the config's value for the current step, used to
+// pre-fill the input when editing.
+func (m tuiModel) currentValue() string {
+	if !m.prefill || m.current >= len(m.fields) {
+		return ""
+	}
+	switch m.fields[m.current] {
+	case fieldName:
+		return m.config.Name
+	case fieldImage:
+		return m.config.Image
+	case fieldDomain:
+		return m.config.Domain
+	case fieldSubdomain:
+		return m.config.Subdomain
+	case fieldPort:
+		if m.config.Port > 0 {
+			return strconv.Itoa(m.config.Port)
+		}
+	case fieldNetwork:
+		return m.config.Network
+	case fieldMounts:
+		return strings.Join(m.config.Mounts, ", ")
+	case fieldEnvVars:
+		pairs := make([]string, 0, len(m.config.Env))
+		for _, key := range sortedEnvKeys(m.config.Env) {
+			pairs = append(pairs, key+"="+m.config.Env[key])
+		}
+		return strings.Join(pairs, "\n")
+	case fieldHealthPath:
+		return m.config.HealthPath
+	case fieldHealthCmd:
+		return m.config.HealthCmd
 	}
+	return ""
+}
+
+// multiline reports whether the current step is entered in the text area,
+// where enter starts a new line and ctrl+d continues.
+func (m tuiModel) multiline() bool {
+	return m.current < len(m.fields) && m.fields[m.current] == fieldEnvVars
 }
 
 func (m tuiModel) Init() tea.Cmd {
@@ -98,44 +191,64 @@ func (m tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		switch msg.String() {
 		case "ctrl+c", "esc":
 			return m, tea.Quit
-		case "enter":
+		case "enter", "ctrl+d":
+			if (msg.String() == "enter") == m.multiline() {
+				break
+			}
 			value := strings.TrimSpace(m.input.Value())
+			if m.multiline() {
+				value = strings.TrimSpace(m.area.Value())
+			}
 			m.err = ""
 
 			switch m.fields[m.current] {
 			case fieldName:
-				if value == "" {
-					m.err = "Project name is required"
+				if err := validateName(value); err != nil {
+					m.err = err.Error()
 					return m, nil
 				}
 				m.config.Name = value
 			case fieldImage:
-				if value == "" {
-					m.err = "Docker image is required"
+				if err := validateImage(value); err != nil {
+					m.err = err.Error()
 					return m, nil
 				}
 				m.config.Image = value
 			case fieldDomain:
 				if value == "" {
-					m.err = "Domain is required"
+					value = m.config.Domain // default from .rollout.yaml, if any
+				}
+				if err := validateDomain(value); err != nil {
+					m.err = err.Error()
 					return m, nil
 				}
 				m.config.Domain = value
 			case fieldSubdomain:
+				if err := validateSubdomain(value); err != nil {
+					m.err = err.Error()
+					return m, nil
+				}
 				m.config.Subdomain = value // optional
 			case fieldPort:
 				if value == "" {
 					value = "80"
 				}
-				if port, err := strconv.Atoi(value); err == nil && port > 0 && port < 65536 {
-					m.config.Port = port
-				} else {
-					m.err = "Enter a valid port (1-65535)"
+				port, err := strconv.Atoi(value)
+				if err != nil {
+					m.err = invalid("port", value, "must be a number").Error()
+					return m, nil
+				}
+				if err := validatePort("port", port); err != nil {
+					m.err = err.Error()
 					return m, nil
 				}
+				m.config.Port = port
 			case fieldNetwork:
 				if value == "" {
 					// keep existing default
+				} else if err := validateNetwork(value); err != nil {
+					m.err = err.Error()
+					return m, nil
 				} else {
 					m.config.Network = value
 				}
@@ -170,19 +283,52 @@ func (m tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 				// if mode is not file, skip
 			case fieldMounts:
-				if value == "" {
+				switch value {
+				case "":
+					// keep the current mounts
+				case "-":
 					m.config.Mounts = nil
-				} else {
+				default:
 					parts := strings.Split(value, ",")
 					out := make([]string, 0, len(parts))
 					for _, p := range parts {
 						p = strings.TrimSpace(p)
-						if p != "" {
-							out = append(out, p)
+						if p == "" {
+							continue
+						}
+						if err := validateMount(p); err != nil {
+							m.err = err.Error()
+							return m, nil
 						}
+						out = append(out, p)
 					}
 					m.config.Mounts = out
 				}
+			case fieldEnvVars:
+				var assignments []string
+				for _, p := range strings.Split(value, "\n") {
+					if p = strings.TrimSpace(p); p != "" {
+						assignments = append(assignments, p)
+					}
+				}
+				env, err := buildEnv(nil, "", assignments)
+				if err != nil {
+					m.err = err.Error()
+					return m, nil
+				}
+				m.config.Env = env
+			case fieldHealthPath:
+				if err := validateHealthPath(value); err != nil {
+					m.err = err.Error()
+					return m, nil
+				}
+				m.config.HealthPath = value
+			case fieldHealthCmd:
+				if err := validateHealthCmd(value); err != nil {
+					m.err = err.Error()
+					return m, nil
+				}
+				m.config.HealthCmd = value
 			}
 
 			// next field or finish
@@ -194,17 +340,26 @@ func (m tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			} else {
 				m.current++
 			}
-			m.input.SetValue("")
+			m.input.SetValue(m.currentValue())
 			if m.current >= len(m.fields) {
 				m.finished = true
 				return m, tea.Quit
 			}
+			if m.multiline() {
+				m.area.SetValue(m.currentValue())
+				return m, m.area.Focus()
+			}
+			m.area.Blur()
 			return m, textinput.Blink
 		}
 	}
 
 	var cmd tea.Cmd
-	m.input, cmd = m.input.Update(msg)
+	if m.multiline() {
+		m.area, cmd = m.area.Update(msg)
+	} else {
+		m.input, cmd = m.input.Update(msg)
+	}
 	return m, cmd
 }
 
@@ -214,7 +369,7 @@ func (m tuiModel) View() string {
 	}
 
 	var b strings.Builder
-	b.WriteString(headerStyle.Render("Rollout Init") + "\n")
+	b.WriteString(headerStyle.Render(m.title) + "\n")
 	b.WriteString(subHeaderStyle.Render(fmt.Sprintf("Step %d of %d", m.current+1, len(m.fields))) + "\n\n")
 
 	var prompt, placeholder, help string
@@ -228,6 +383,9 @@ func (m tuiModel) View() string {
 	case fieldDomain:
 		prompt = "Main Domain"
 		placeholder = "example.com"
+		if m.config.Domain != "" {
+			placeholder = m.config.Domain
+		}
 	case fieldSubdomain:
 		prompt = "Subdomain (optional)"
 		placeholder = "api"
@@ -247,6 +405,19 @@ func (m tuiModel) View() string {
 	case fieldMounts:
 		prompt = "Mounts (comma-separated)"
 		placeholder = "/host:/container:rw, name:/container:ro"
+		help = "leave empty to keep the current mounts, or enter - for none"
+	case fieldEnvVars:
+		prompt = "Environment variables (optional)"
+		placeholder = "NODE_ENV=production"
+		help = "one KEY=VALUE per line; plain text, visible in the repo, so use secrets for anything sensitive"
+	case fieldHealthPath:
+		prompt = "Health check path (optional)"
+		placeholder = "/healthz"
+		help = "traefik stops routing to the app while this path fails"
+	case fieldHealthCmd:
+		prompt = "Health check command (optional)"
+		placeholder = "curl -fs http://localhost/healthz"
+		help = "run by docker inside the container"
 	}
 
 	m.input.Placeholder = placeholder
@@ -259,13 +430,20 @@ func (m tuiModel) View() string {
 	// Use accent color for prompts to balance UI colors
 	tuiPromptStyle := lipgloss.NewStyle().Foreground(accentColor).Bold(true)
 	b.WriteString(tuiPromptStyle.Render(prompt) + "\n")
-	b.WriteString(inputStyle.Render(m.input.View()) + "\n")
+	continueKey := "Enter"
+	if m.multiline() {
+		m.area.Placeholder = placeholder
+		b.WriteString(inputStyle.Render(m.area.View()) + "\n")
+		continueKey = "Ctrl+D"
+	} else {
+		b.WriteString(inputStyle.Render(m.input.View()) + "\n")
+	}
 	if help != "" {
 		b.WriteString(mutedStyle.Render(help) + "\n")
 	}
 	if m.err != "" {
 		b.WriteString(errorStyle.Render("Error: "+m.err) + "\n")
 	}
-	b.WriteString(mutedStyle.Render("Press Enter to continue, Ctrl+C to cancel"))
+	b.WriteString(mutedStyle.Render("Press " + continueKey + " to continue, Ctrl+C to cancel"))
 	return b.String()
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Transaction groups the file changes made by a mutating command so they can
+// be undone together. Every file is snapshotted before its first change, by
+// us or by an external tool like an editor; if any step fails, Rollback puts
+// the snapshots back.
+type Transaction struct {
+	snapshots map[string]*fileSnapshot
+	order     []string
+}
+
+type fileSnapshot struct {
+	existed bool
+	data    []byte
+	mode    os.FileMode
+}
+
+// StepError is returned by Transaction.Step and names the step that failed.
+type StepError struct {
+	Step string
+	Err  error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("%s failed: %v", e.Step, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+func newTransaction() *Transaction {
+	return &Transaction{snapshots: make(map[string]*fileSnapshot)}
+}
+
+// Track snapshots a file that is about to change. Tracking the same path
+// again keeps the first snapshot.
+func (t *Transaction) Track(path string) error {
+	if _, ok := t.snapshots[path]; ok {
+		return nil
+	}
+
+	snap := &fileSnapshot{}
+	info, err := os.Stat(path)
+	switch {
+	case err == nil:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to snapshot %s: %w", path, err)
+		}
+		snap.existed = true
+		snap.data = data
+		snap.mode = info.Mode().Perm()
+	case !os.IsNotExist(err):
+		return fmt.Errorf("failed to snapshot %s: %w", path, err)
+	}
+
+	t.snapshots[path] = snap
+	t.order = append(t.order, path)
+	return nil
+}
+
+// WriteFile tracks path and atomically replaces its contents.
+func (t *Transaction) WriteFile(path string, data []byte, perm os.FileMode) error {
+	if err := t.Track(path); err != nil {
+		return err
+	}
+	return atomicWriteFile(path, data, perm)
+}
+
+// Remove tracks path and deletes it.
+func (t *Transaction) Remove(path string) error {
+	if err := t.Track(path); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Step runs one named step of the transaction and wraps any error in a
+// StepError so the failure can be reported by name.
+func (t *Transaction) Step(name string, fn func() error) error {
+	if err := fn(); err != nil {
+		return &StepError{Step: name, Err: err}
+	}
+	return nil
+}
+
+// Rollback restores every tracked file to its snapshot, newest first, and
+// returns the paths it restored. It keeps going past errors and reports the
+// first one.
+func (t *Transaction) Rollback() ([]string, error) {
+	var (
+		restored []string
+		firstErr error
+	)
+
+	for i := len(t.order) - 1; i >= 0; i-- {
+		path := t.order[i]
+		snap := t.snapshots[path]
+
+		var err error
+		if snap.existed {
+			err = atomicWriteFile(path, snap.data, snap.mode)
+		} else if err = os.Remove(path); os.IsNotExist(err) {
+			err = nil
+		}
+
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to restore %s: %w", path, err)
+			}
+			continue
+		}
+		restored = append(restored, path)
+	}
+
+	t.snapshots = make(map[string]*fileSnapshot)
+	t.order = nil
+	return restored, firstErr
+}
+
+// Commit keeps every change and forgets the snapshots.
+func (t *Transaction) Commit() {
+	t.snapshots = make(map[string]*fileSnapshot)
+	t.order = nil
+}
+
+// abortTransaction reports a failed step, rolls the transaction back and
+// exits.
+func abortTransaction(tx *Transaction, err error) {
+	fmt.Println(errorStyle.Render("✗ " + err.Error()))
+
+	restored, rbErr := tx.Rollback()
+	for _, path := range restored {
+		fmt.Println(mutedStyle.Render("↩ Restored " + path))
+	}
+	if rbErr != nil {
+		fmt.Println(errorStyle.Render("✗ Rollback incomplete: " + rbErr.Error()))
+	} else {
+		fmt.Println(mutedStyle.Render("ℹ️ All changes were rolled back"))
+	}
+
+	os.Exit(1)
+}
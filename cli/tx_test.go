@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path, content string, perm os.FileMode) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), perm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	dir := t.TempDir()
+	changed := filepath.Join(dir, "ports.json")
+	removed := filepath.Join(dir, "web.age")
+	created := filepath.Join(dir, "web.nix")
+	twice := filepath.Join(dir, "secrets.nix")
+	writeTestFile(t, changed, "old ports", 0o600)
+	writeTestFile(t, removed, "secret", 0o644)
+	writeTestFile(t, twice, "original", 0o644)
+
+	tx := newTransaction()
+	steps := []struct {
+		name string
+		fn   func() error
+	}{
+		{"write ports", func() error { return tx.WriteFile(changed, []byte("new ports"), 0o644) }},
+		{"remove secret", func() error { return tx.Remove(removed) }},
+		{"create app", func() error { return tx.WriteFile(created, []byte("app"), 0o644) }},
+		{"first edit", func() error { return tx.WriteFile(twice, []byte("first"), 0o644) }},
+		// the snapshot taken before the first edit is the one restored
+		{"second edit", func() error { return tx.WriteFile(twice, []byte("second"), 0o644) }},
+	}
+	for _, step := range steps {
+		if err := tx.Step(step.name, step.fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restored, err := tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{twice, created, removed, changed}; !slices.Equal(restored, want) {
+		t.Errorf("restored = %q, want newest first %q", restored, want)
+	}
+
+	files := []struct {
+		path    string
+		content string
+		perm    os.FileMode
+	}{
+		{changed, "old ports", 0o600},
+		{removed, "secret", 0o644},
+		{twice, "original", 0o644},
+	}
+	for _, f := range files {
+		data, err := os.ReadFile(f.path)
+		if err != nil {
+			t.Errorf("%s: %v", f.path, err)
+			continue
+		}
+		if string(data) != f.content {
+			t.Errorf("%s = %q, want %q", f.path, data, f.content)
+		}
+		if info, err := os.Stat(f.path); err == nil && info.Mode().Perm() != f.perm {
+			t.Errorf("%s mode = %v, want %v", f.path, info.Mode().Perm(), f.perm)
+		}
+	}
+	if fileExists(created) {
+		t.Errorf("%s still exists after rollback", created)
+	}
+}
+
+func TestTransactionCommitKeepsChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ports.json")
+	writeTestFile(t, path, "old", 0o644)
+
+	tx := newTransaction()
+	if err := tx.WriteFile(path, []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tx.Commit()
+
+	restored, err := tx.Rollback()
+	if err != nil || len(restored) != 0 {
+		t.Fatalf("Rollback after Commit = %q, %v, want nothing to restore", restored, err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new" {
+		t.Errorf("content = %q, want the committed %q", data, "new")
+	}
+}
+
+func TestTransactionStepError(t *testing.T) {
+	tx := newTransaction()
+	cause := errors.New("disk full")
+	err := tx.Step("Saving port registry", func() error { return cause })
+
+	var stepErr *StepError
+	if !errors.As(err, &stepErr) || stepErr.Step != "Saving port registry" {
+		t.Fatalf("error = %v, want a StepError naming the step", err)
+	}
+	if !errors.Is(err, cause) {
+		t.Errorf("error = %v, want it to wrap %v", err, cause)
+	}
+	if got, want := err.Error(), "Saving port registry failed: disk full"; got != want {
+		t.Errorf("message = %q, want %q", got, want)
+	}
+}
+
+func TestTransactionRollbackKeepsGoing(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good")
+	blocked := filepath.Join(dir, "sub", "blocked")
+	writeTestFile(t, good, "before", 0o644)
+	if err := os.Mkdir(filepath.Dir(blocked), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, blocked, "before", 0o644)
+
+	tx := newTransaction()
+	for _, path := range []string{good, blocked} {
+		if err := tx.WriteFile(path, []byte("after"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// replacing the directory with a file makes restoring blocked fail
+	if err := os.RemoveAll(filepath.Dir(blocked)); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Dir(blocked), "", 0o644)
+
+	restored, err := tx.Rollback()
+	if err == nil {
+		t.Fatal("error = nil, want the failed restore")
+	}
+	if !slices.Equal(restored, []string{good}) {
+		t.Errorf("restored = %q, want %q", restored, []string{good})
+	}
+	if data, _ := os.ReadFile(good); string(data) != "before" {
+		t.Errorf("%s = %q, want %q", good, data, "before")
+	}
+}
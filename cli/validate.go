@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ValidationError reports a single rejected field, named after its flag.
+type ValidationError struct {
+	Field  string
+	Value  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("%s %s", e.Field, e.Reason)
+	}
+	return fmt.Sprintf("invalid %s %q: %s", e.Field, e.Value, e.Reason)
+}
+
+func invalid(field, value, reason string, args ...any) error {
+	return &ValidationError{Field: field, Value: value, Reason: fmt.Sprintf(reason, args...)}
+}
+
+const (
+	maxNameLength     = 63
+	maxHostnameLength = 253
+	maxLabelLength    = 63
+	maxImageLength    = 255
+)
+
+var (
+	namePattern    = regexp.MustCompile(`^[a-z0-9]([a-z0-9_-]*[a-z0-9])?$`)
+	labelPattern   = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+	networkPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+	volumePattern  = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+	// OCI image references, following the grammar used by the distribution
+	// project: [registry[:port]/]path[/path...][:tag][@digest]
+	imagePattern = func() *regexp.Regexp {
+		domainComponent := `(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])`
+		domain := domainComponent + `(?:\.` + domainComponent + `)*(?::[0-9]+)?`
+		pathComponent := `[a-z0-9]+(?:(?:[._]|__|[-]+)[a-z0-9]+)*`
+		tag := `[\w][\w.-]{0,127}`
+		digest := `[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}`
+		return regexp.MustCompile(`^(?:` + domain + `/)?` + pathComponent + `(?:/` + pathComponent + `)*(?::` + tag + `)?(?:@` + digest + `)?$`)
+	}()
+)
+
+// validateName checks an app name. It ends up in traefik router keys, the
+// docker-<name> systemd unit and file names, so it is kept to a safe subset.
+func validateName(name string) error {
+	if name == "" {
+		return invalid("name", name, "is required")
+	}
+	if len(name) > maxNameLength {
+		return invalid("name", name, "must be at most %d characters", maxNameLength)
+	}
+	if !namePattern.MatchString(name) {
+		return invalid("name", name, "must only contain lowercase letters, digits, '-' and '_', and start and end with a letter or digit")
+	}
+	// companion containers, the private network and the basic auth secret
+	// are named after the app
+	for _, suffix := range append(append([]string{}, companionServiceNames...), "private", "basic-auth") {
+		if strings.HasSuffix(name, "-"+suffix) {
+			return invalid("name", name, "must not end in -%s, which is reserved for names rollout generates", suffix)
+		}
+	}
+	return nil
+}
+
+// validateHostname checks an RFC 1123 hostname.
+func validateHostname(field, host string) error {
+	if host == "" {
+		return invalid(field, host, "is required")
+	}
+	if len(host) > maxHostnameLength {
+		return invalid(field, host, "must be at most %d characters", maxHostnameLength)
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" {
+			return invalid(field, host, "must not contain empty labels")
+		}
+		if len(label) > maxLabelLength {
+			return invalid(field, host, "label %q is longer than %d characters", label, maxLabelLength)
+		}
+		if !labelPattern.MatchString(label) {
+			return invalid(field, host, "label %q must only contain letters, digits and '-', and not start or end with '-'", label)
+		}
+	}
+	return nil
+}
+
+func validateDomain(domain string) error {
+	if err := validateHostname("domain", domain); err != nil {
+		return err
+	}
+	if !strings.Contains(domain, ".") {
+		return invalid("domain", domain, "must be a fully qualified domain (e.g., example.com)")
+	}
+	return nil
+}
+
+// validateSubdomain checks an optional subdomain, which may itself contain
+// dots (e.g., api.staging).
+func validateSubdomain(subdomain string) error {
+	if subdomain == "" {
+		return nil
+	}
+	return validateHostname("subdomain", subdomain)
+}
+
+func validateImage(image string) error {
+	if image == "" {
+		return invalid("image", image, "is required")
+	}
+	if len(image) > maxImageLength {
+		return invalid("image", image, "must be at most %d characters", maxImageLength)
+	}
+	if !imagePattern.MatchString(image) {
+		return invalid("image", image, "is not a valid OCI image reference (e.g., ghcr.io/owner/app:latest)")
+	}
+	return nil
+}
+
+func validatePort(field string, port int) error {
+	if port < 1 || port > 65535 {
+		return invalid(field, fmt.Sprint(port), "must be between 1 and 65535")
+	}
+	return nil
+}
+
+func validateNetwork(network string) error {
+	if network == "" {
+		return invalid("network", network, "is required")
+	}
+	if !networkPattern.MatchString(network) {
+		return invalid("network", network, "must only contain letters, digits, '_', '.' and '-'")
+	}
+	return nil
+}
+
+var pathPrefixPattern = regexp.MustCompile(`^(/[A-Za-z0-9._~-]+)+$`)
+
+// validatePathPrefix checks an optional router path prefix such as /api.
+func validatePathPrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	if !strings.HasPrefix(prefix, "/") {
+		return invalid("path-prefix", prefix, "must start with '/'")
+	}
+	if prefix == "/" || strings.HasSuffix(prefix, "/") {
+		return invalid("path-prefix", prefix, "must not end with '/' (leave it empty to route the whole host)")
+	}
+	if !pathPrefixPattern.MatchString(prefix) {
+		return invalid("path-prefix", prefix, "must only contain letters, digits, '.', '_', '~', '-' and '/'")
+	}
+	return nil
+}
+
+// validateMount checks a mount of the form /host:/container[:ro|rw] or
+// volume:/container[:ro|rw].
+func validateMount(mount string) error {
+	if mount == "" {
+		return invalid("mount", mount, "must not be empty")
+	}
+	if strings.ContainsAny(mount, " \t\n\"") {
+		return invalid("mount", mount, "must not contain whitespace or quotes")
+	}
+
+	parts := strings.Split(mount, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return invalid("mount", mount, "must be /host:/container[:ro|rw] or volume:/container[:ro|rw]")
+	}
+
+	source, target := parts[0], parts[1]
+	if strings.HasPrefix(source, "/") {
+		if len(source) > 1 && strings.HasSuffix(source, "/") {
+			return invalid("mount", mount, "host path must not end with '/'")
+		}
+	} else if !volumePattern.MatchString(source) {
+		return invalid("mount", mount, "source must be an absolute host path or a volume name")
+	}
+
+	if !strings.HasPrefix(target, "/") {
+		return invalid("mount", mount, "container path must be absolute")
+	}
+
+	if len(parts) == 3 && parts[2] != "ro" && parts[2] != "rw" {
+		return invalid("mount", mount, "mode must be ro or rw, got %q", parts[2])
+	}
+
+	return nil
+}
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+const envKeyReason = "must start with a letter or '_' and contain only letters, digits and '_'"
+
+// validateEnvKey checks an environment variable name.
+func validateEnvKey(key string) error {
+	if !envKeyPattern.MatchString(key) {
+		return invalid("env", key, envKeyReason)
+	}
+	return nil
+}
+
+// validateAppConfig checks every field of an app and returns all problems
+// found, in flag order.
+func validateAppConfig(c AppConfig) []error {
+	var errs []error
+	add := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	add(validateName(c.Name))
+	add(validateImage(c.Image))
+	if c.Internal {
+		// no router, so there is no hostname, port or traefik network to
+		// check, only routing options that must be left unset
+		errs = append(errs, validateInternal(c)...)
+	} else {
+		add(validateDomain(c.Domain))
+		add(validateSubdomain(c.Subdomain))
+	}
+
+	primary := c.Domain
+	if c.Subdomain != "" {
+		primary = c.Subdomain + "." + c.Domain
+	}
+	hosts := map[string]bool{}
+	serve := func(h string) {
+		hosts[strings.ToLower(h)] = true
+		if !c.NoWWW {
+			hosts["www."+strings.ToLower(h)] = true
+		}
+	}
+	serve(primary)
+	for i, h := range c.Aliases {
+		add(validateHostname("host", h))
+		switch {
+		case hosts[strings.ToLower(h)]:
+			add(invalid("host", h, "is already served by this app"))
+		case c.NoWWW && i == 0 && strings.EqualFold(h, "www."+primary):
+			// the rule would read back as www. aliases being on
+			add(invalid("host", h, "drop --no-www instead of listing the www. alias"))
+		}
+		serve(h)
+	}
+	add(validatePathPrefix(c.PathPrefix))
+	if c.StripPrefix && c.PathPrefix == "" {
+		add(invalid("strip-prefix", "true", "needs a --path-prefix to strip"))
+	}
+	if c.Priority < 0 {
+		add(invalid("priority", fmt.Sprint(c.Priority), "must not be negative"))
+	}
+	if !c.Internal {
+		add(validatePort("port", c.Port))
+	}
+	if c.BasicAuthUser != "" {
+		add(validateBasicAuthUser(c.BasicAuthUser))
+	}
+	for _, ip := range c.AllowIPs {
+		add(validateAllowIP(ip))
+	}
+	add(validateRateLimit(c.RateLimit))
+	add(validateHealthPath(c.HealthPath))
+	add(validateHealthCmd(c.HealthCmd))
+	add(validateDuration("health-interval", c.HealthInterval))
+	add(validateDuration("health-timeout", c.HealthTimeout))
+	if c.HealthRetries < 0 {
+		add(invalid("health-retries", fmt.Sprint(c.HealthRetries), "must not be negative"))
+	}
+	add(validateMemory(c.Memory))
+	add(validateCPUs(c.CPUs))
+	add(validateRestart(c.Restart))
+	add(validateUser(c.User))
+	for _, t := range c.Tmpfs {
+		add(validateTmpfs(t))
+	}
+	for _, capability := range c.CapDrop {
+		add(validateCapability(capability))
+	}
+	if !c.Internal {
+		add(validateNetwork(c.Network))
+	}
+	seenNetwork := map[string]bool{}
+	for _, n := range c.JoinNetworks {
+		add(validateJoinNetwork(c, n))
+		if seenNetwork[n] {
+			add(invalid("join-network", n, "is listed more than once"))
+		}
+		seenNetwork[n] = true
+	}
+
+	seen := map[string]bool{}
+	for _, m := range c.Mounts {
+		add(validateMount(m))
+		if target := strings.Split(m, ":"); len(target) >= 2 {
+			if seen[target[1]] {
+				add(invalid("mount", m, "container path %s is mounted more than once", target[1]))
+			}
+			seen[target[1]] = true
+		}
+	}
+
+	seenService := map[string]bool{}
+	for _, s := range c.Services {
+		add(validateService(s))
+		if seenService[s] {
+			add(invalid("with", s, "is listed more than once"))
+		}
+		seenService[s] = true
+	}
+
+	for _, key := range sortedEnvKeys(c.Env) {
+		add(validateEnvKey(key))
+	}
+
+	errs = append(errs, validateEnvSchema(c.RequireEnv, c.OptionalEnv)...)
+	// only a secret can provide them, checked once it is written
+	schema := EnvSchema{Required: c.RequireEnv}
+	if missing := schema.missingEnv(nil, companionEnvKeys(c.Services)...); len(missing) > 0 && c.EnvFile == "" && !c.EditEnv {
+		add(invalid("require-env", strings.Join(missing, ","), "needs --env-file or --edit to provide the secret"))
+	}
+
+	return errs
+}
+
+// exitOnValidationErrors prints every error and exits if there are any.
+func exitOnValidationErrors(errs []error) {
+	if len(errs) == 0 {
+		return
+	}
+	for _, err := range errs {
+		fmt.Println(errorStyle.Render("✗ " + err.Error()))
+	}
+	os.Exit(1)
+}
+
+// mustValidateAppArg exits unless an <app> argument is a valid name, so it
+// can't reach outside the apps directory once joined into a path.
+func mustValidateAppArg(name string) {
+	if err := validateName(name); err != nil {
+		fmt.Println(errorStyle.Render("✗ " + err.Error()))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// checkValid fails unless err is nil when ok, or a *ValidationError
+// mentioning reason otherwise.
+func checkValid(t *testing.T, call string, err error, ok bool, reason string) {
+	t.Helper()
+	if ok {
+		if err != nil {
+			t.Errorf("%s = %v, want nil", call, err)
+		}
+		return
+	}
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Errorf("%s = %v, want a *ValidationError", call, err)
+		return
+	}
+	if !strings.Contains(verr.Reason, reason) {
+		t.Errorf("%s = %v, want a reason containing %q", call, err, reason)
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		name   string
+		ok     bool
+		reason string
+	}{
+		{"web", true, ""},
+		{"my_app-2", true, ""},
+		{"", false, "is required"},
+		{"Web", false, "lowercase"},
+		{"-web", false, "start and end"},
+		{"web-", false, "start and end"},
+		{"../web", false, "lowercase"},
+		{"web/../../etc", false, "lowercase"},
+		{"web.nix", false, "lowercase"},
+		{strings.Repeat("a", maxNameLength), true, ""},
+		{strings.Repeat("a", maxNameLength+1), false, "at most"},
+		{"web-private", false, "reserved"},
+		{"web-basic-auth", false, "reserved"},
+		{"web-postgres", false, "reserved"},
+	}
+	for _, tt := range tests {
+		checkValid(t, "validateName("+tt.name+")", validateName(tt.name), tt.ok, tt.reason)
+	}
+}
+
+func TestValidateDomain(t *testing.T) {
+	tests := []struct {
+		domain string
+		ok     bool
+		reason string
+	}{
+		{"example.com", true, ""},
+		{"sub.example.co.uk", true, ""},
+		{"localhost", false, "fully qualified"},
+		{"", false, "is required"},
+		{"example..com", false, "empty labels"},
+		{"-example.com", false, "not start or end"},
+		{"exa_mple.com", false, "only contain"},
+		{strings.Repeat("a", maxLabelLength+1) + ".com", false, "longer than"},
+	}
+	for _, tt := range tests {
+		checkValid(t, "validateDomain("+tt.domain+")", validateDomain(tt.domain), tt.ok, tt.reason)
+	}
+
+	checkValid(t, "validateSubdomain()", validateSubdomain(""), true, "")
+	checkValid(t, "validateSubdomain(api.staging)", validateSubdomain("api.staging"), true, "")
+	checkValid(t, "validateSubdomain(api..staging)", validateSubdomain("api..staging"), false, "empty labels")
+}
+
+func TestValidateImage(t *testing.T) {
+	tests := []struct {
+		image string
+		ok    bool
+	}{
+		{"nginx", true},
+		{"nginx:1.27-alpine", true},
+		{"ghcr.io/owner/app:latest", true},
+		{"localhost:5000/app", true},
+		{"ghcr.io/owner/app@" + testDigest, true},
+		{"ghcr.io/owner/app:main@" + testDigest, true},
+		{"", false},
+		{"Owner/App", false},
+		{"app:", false},
+		{"app:tag with space", false},
+		{"app@sha256:abc", false},
+		{`app"; rm -rf /`, false},
+	}
+	for _, tt := range tests {
+		err := validateImage(tt.image)
+		if (err == nil) != tt.ok {
+			t.Errorf("validateImage(%q) = %v, want ok = %v", tt.image, err, tt.ok)
+		}
+	}
+}
+
+func TestValidateMount(t *testing.T) {
+	tests := []struct {
+		mount  string
+		ok     bool
+		reason string
+	}{
+		{"/srv/data:/data", true, ""},
+		{"/srv/data:/data:ro", true, ""},
+		{"cache:/cache:rw", true, ""},
+		{"/:/host:ro", true, ""},
+		{"", false, "must not be empty"},
+		{"/srv/data", false, "must be /host"},
+		{"/a:/b:ro:z", false, "must be /host"},
+		{"/srv/data/:/data", false, "must not end with '/'"},
+		{"./data:/data", false, "absolute host path or a volume"},
+		{"/srv/data:data", false, "container path must be absolute"},
+		{"/srv/data:/data:rx", false, "mode must be ro or rw"},
+		{"/srv/my data:/data", false, "whitespace or quotes"},
+		{`/srv/"x:/data`, false, "whitespace or quotes"},
+	}
+	for _, tt := range tests {
+		checkValid(t, "validateMount("+tt.mount+")", validateMount(tt.mount), tt.ok, tt.reason)
+	}
+}
+
+func TestValidatePathPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		ok     bool
+		reason string
+	}{
+		{"", true, ""},
+		{"/api", true, ""},
+		{"/api/v1", true, ""},
+		{"api", false, "must start with '/'"},
+		{"/", false, "must not end with '/'"},
+		{"/api/", false, "must not end with '/'"},
+		{"/api//v1", false, "only contain"},
+		{"/a`b", false, "only contain"},
+	}
+	for _, tt := range tests {
+		checkValid(t, "validatePathPrefix("+tt.prefix+")", validatePathPrefix(tt.prefix), tt.ok, tt.reason)
+	}
+}
+
+func TestValidateEnvKey(t *testing.T) {
+	for _, key := range []string{"PORT", "_private", "DATABASE_URL2"} {
+		checkValid(t, "validateEnvKey("+key+")", validateEnvKey(key), true, "")
+	}
+	for _, key := range []string{"", "2FAST", "WITH-DASH", "A B", "A=B"} {
+		checkValid(t, "validateEnvKey("+key+")", validateEnvKey(key), false, "must start with")
+	}
+}
+
+func TestValidatePort(t *testing.T) {
+	for _, port := range []int{1, 80, 65535} {
+		checkValid(t, "validatePort", validatePort("port", port), true, "")
+	}
+	for _, port := range []int{0, -1, 65536} {
+		checkValid(t, "validatePort", validatePort("port", port), false, "between 1 and 65535")
+	}
+}
+
+func TestValidateAppConfig(t *testing.T) {
+	valid := func() AppConfig {
+		return AppConfig{
+			Name:    "web",
+			Image:   "nginx:latest",
+			Domain:  "example.com",
+			Port:    80,
+			Network: "traefik",
+		}
+	}
+
+	tests := []struct {
+		name   string
+		edit   func(*AppConfig)
+		fields []string
+	}{
+		{"valid", func(c *AppConfig) {}, nil},
+		{"internal without a domain", func(c *AppConfig) { c.Internal, c.Domain, c.Port = true, "", 0 }, nil},
+		{"bad name and image", func(c *AppConfig) { c.Name, c.Image = "Web", "" }, []string{"name", "image"}},
+		{"duplicate alias", func(c *AppConfig) { c.Aliases = []string{"www.example.com"} }, []string{"host"}},
+		{"strip without prefix", func(c *AppConfig) { c.StripPrefix = true }, []string{"strip-prefix"}},
+		{"mount target twice", func(c *AppConfig) { c.Mounts = []string{"/a:/data", "/b:/data"} }, []string{"mount"}},
+		{"bad env key", func(c *AppConfig) { c.Env = map[string]string{"BAD-KEY": "x"} }, []string{"env"}},
+		{"negative priority", func(c *AppConfig) { c.Priority = -1 }, []string{"priority"}},
+		{"missing network", func(c *AppConfig) { c.Network = "" }, []string{"network"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.edit(&c)
+			errs := validateAppConfig(c)
+			var fields []string
+			for _, err := range errs {
+				var verr *ValidationError
+				if !errors.As(err, &verr) {
+					t.Fatalf("error %v is not a *ValidationError", err)
+				}
+				fields = append(fields, verr.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+				t.Errorf("rejected fields = %q, want %q (%v)", fields, tt.fields, errs)
+			}
+		})
+	}
+}